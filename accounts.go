@@ -0,0 +1,190 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dkorunic/IM-billing-v2/oauth"
+	"github.com/google/renameio/v2/maybe"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// DefaultAccount is the account used without --account, stored in the pre-account token location.
+	DefaultAccount = "default"
+
+	// accountsFile lists accounts with a stored token, since keyring entries cannot be enumerated.
+	accountsFile = "accounts.json"
+
+	// accountsDir holds tokens of named accounts inside the config directory.
+	accountsDir = "accounts"
+)
+
+var ErrAccountName = errors.New("invalid account name, use letters, digits, '.', '_' and '-'")
+
+// accountNameRe matches account names safe for use as file names and keyring entries.
+var accountNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// accountCalendar is a calendar of a named account taking part in a report.
+type accountCalendar struct {
+	account  string
+	calendar string
+}
+
+// String returns the account and calendar as ACCOUNT:CALENDAR, with primary for the default calendar.
+func (a accountCalendar) String() string {
+	calName := a.calendar
+	if calName == "" {
+		calName = "primary"
+	}
+
+	return a.account + ":" + calName
+}
+
+// validateAccount checks that an account name is safe for use as a file name and keyring entry.
+func validateAccount(name string) error {
+	if !accountNameRe.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrAccountName, name)
+	}
+
+	return nil
+}
+
+// parseAccountCalendar parses ACCOUNT[:CALENDAR], where an omitted calendar selects the primary calendar.
+func parseAccountCalendar(s string) (accountCalendar, error) {
+	account, calName, _ := strings.Cut(s, ":")
+
+	if err := validateAccount(account); err != nil {
+		return accountCalendar{}, err
+	}
+
+	return accountCalendar{account: account, calendar: calName}, nil
+}
+
+// configPath returns a path inside the config directory, falling back to the working directory.
+func configPath(elem ...string) string {
+	if dir, err := defaultConfigDir(); err == nil {
+		return filepath.Join(append([]string{dir}, elem...)...)
+	}
+
+	return filepath.Join(elem...)
+}
+
+// accountTokenFile returns the default token file of an account for the configured token storage.
+func accountTokenFile(account string) string {
+	name := DefaultTokenFile
+	if *tokenStore == tokenStoreEncrypted {
+		name = DefaultEncTokenFile
+	}
+
+	if account == DefaultAccount {
+		return configPath(name)
+	}
+
+	return configPath(accountsDir, account+strings.TrimPrefix(name, "token"))
+}
+
+// loadAccounts reads the account registry at path; a missing registry holds no accounts.
+func loadAccounts(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var accounts []string
+
+	if err := json.Unmarshal(b, &accounts); err != nil {
+		return nil, fmt.Errorf("invalid account registry %s: %w", path, err)
+	}
+
+	return accounts, nil
+}
+
+// saveAccounts atomically writes the sorted account registry to path.
+func saveAccounts(path string, accounts []string) error {
+	slices.Sort(accounts)
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(slices.Compact(accounts)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), oauth.DefaultDirPerms); err != nil {
+		return err
+	}
+
+	return maybe.WriteFile(path, buf.Bytes(), oauth.DefaultPerms)
+}
+
+// registerAccount adds an account to the registry at path.
+func registerAccount(path, account string) error {
+	accounts, err := loadAccounts(path)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(accounts, account) {
+		return nil
+	}
+
+	return saveAccounts(path, append(accounts, account))
+}
+
+// unregisterAccount removes an account from the registry at path.
+func unregisterAccount(path, account string) error {
+	accounts, err := loadAccounts(path)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(accounts, account) {
+		return nil
+	}
+
+	return saveAccounts(path, slices.DeleteFunc(accounts, func(a string) bool { return a == account }))
+}
+
+// registeredStore records the account in the registry whenever its token is saved.
+type registeredStore struct {
+	oauth.TokenStore
+	registry string
+	account  string
+}
+
+// Save stores the token and registers the account.
+func (s *registeredStore) Save(token *oauth2.Token) error {
+	if err := s.TokenStore.Save(token); err != nil {
+		return err
+	}
+
+	return registerAccount(s.registry, s.account)
+}
+
+// Delete removes the token and unregisters the account, even if no token was stored.
+func (s *registeredStore) Delete() error {
+	err := s.TokenStore.Delete()
+	if err != nil && !errors.Is(err, oauth.ErrTokenNotFound) {
+		return err
+	}
+
+	if regErr := unregisterAccount(s.registry, s.account); regErr != nil {
+		return regErr
+	}
+
+	return err
+}
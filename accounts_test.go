@@ -0,0 +1,126 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/dkorunic/IM-billing-v2/oauth"
+	"golang.org/x/oauth2"
+)
+
+// setTokenStoreGlobal sets the token storage backend and restores it on cleanup.
+func setTokenStoreGlobal(t *testing.T, store string) {
+	t.Helper()
+
+	orig := tokenStore
+	t.Cleanup(func() { tokenStore = orig })
+
+	tokenStore = &store
+}
+
+func TestParseAccountCalendar(t *testing.T) {
+	tests := []struct {
+		input string
+		want  accountCalendar
+		label string
+	}{
+		{"work", accountCalendar{account: "work"}, "work:primary"},
+		{"work:Client A", accountCalendar{account: "work", calendar: "Client A"}, "work:Client A"},
+		{"personal:Side: project", accountCalendar{account: "personal", calendar: "Side: project"}, "personal:Side: project"},
+	}
+
+	for _, tc := range tests {
+		got, err := parseAccountCalendar(tc.input)
+		if err != nil {
+			t.Errorf("parseAccountCalendar(%q): %v", tc.input, err)
+
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("parseAccountCalendar(%q): got %+v, want %+v", tc.input, got, tc.want)
+		}
+
+		if got.String() != tc.label {
+			t.Errorf("String(): got %q, want %q", got.String(), tc.label)
+		}
+	}
+
+	for _, bad := range []string{"", ":cal", "../work", "-work:cal"} {
+		if _, err := parseAccountCalendar(bad); !errors.Is(err, ErrAccountName) {
+			t.Errorf("parseAccountCalendar(%q): got %v, want ErrAccountName", bad, err)
+		}
+	}
+}
+
+func TestAccountTokenFile(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	dir := filepath.Join(configHome, "IM-billing-v2")
+
+	setTokenStoreGlobal(t, tokenStoreFile)
+
+	if got, want := accountTokenFile(DefaultAccount), filepath.Join(dir, DefaultTokenFile); got != want {
+		t.Errorf("default account: got %q, want %q", got, want)
+	}
+
+	if got, want := accountTokenFile("work"), filepath.Join(dir, accountsDir, "work.json"); got != want {
+		t.Errorf("named account: got %q, want %q", got, want)
+	}
+
+	setTokenStoreGlobal(t, tokenStoreEncrypted)
+
+	if got, want := accountTokenFile("work"), filepath.Join(dir, accountsDir, "work.json.enc"); got != want {
+		t.Errorf("encrypted named account: got %q, want %q", got, want)
+	}
+}
+
+func TestRegisteredStore_SaveAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	registry := filepath.Join(dir, accountsFile)
+
+	work := &registeredStore{
+		TokenStore: &oauth.FileStore{Path: filepath.Join(dir, "work.json")},
+		registry:   registry,
+		account:    "work",
+	}
+	personal := &registeredStore{
+		TokenStore: &oauth.FileStore{Path: filepath.Join(dir, "personal.json")},
+		registry:   registry,
+		account:    "personal",
+	}
+
+	for _, s := range []*registeredStore{work, personal, work} {
+		if err := s.Save(&oauth2.Token{AccessToken: s.account}); err != nil {
+			t.Fatalf("Save(%s): %v", s.account, err)
+		}
+	}
+
+	accounts, err := loadAccounts(registry)
+	if err != nil {
+		t.Fatalf("loadAccounts: %v", err)
+	}
+
+	if want := []string{"personal", "work"}; !slices.Equal(accounts, want) {
+		t.Errorf("accounts: got %v, want %v", accounts, want)
+	}
+
+	if err := work.Delete(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if accounts, _ = loadAccounts(registry); !slices.Equal(accounts, []string{"personal"}) {
+		t.Errorf("accounts after Delete: got %v, want [personal]", accounts)
+	}
+
+	if err := work.Delete(); !errors.Is(err, oauth.ErrTokenNotFound) {
+		t.Errorf("second Delete: got %v, want ErrTokenNotFound", err)
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/cache"
+	"github.com/dkorunic/IM-billing-v2/oauth"
+	"github.com/peterbourgon/ff/v4"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+var deviceFlag, pasteFlag, localFlag *bool
+
+// Token storage backends.
+const (
+	tokenStoreFile      = "file"
+	tokenStoreEncrypted = "encrypted"
+	tokenStoreKeyring   = "keyring"
+)
+
+// newAuthCommand builds the auth command tree, inheriting global flags from parent.
+func newAuthCommand(parent *ff.FlagSet) *ff.Command {
+	authFS := ff.NewFlagSet("auth").SetParent(parent)
+
+	loginFS := ff.NewFlagSet("login").SetParent(authFS)
+	deviceFlag = loginFS.BoolLong("device", "use device authorization flow for headless hosts")
+	pasteFlag = loginFS.BoolLong("paste", "also accept the redirected URL or authorization code pasted into stdin")
+
+	login := &ff.Command{
+		Name:      "login",
+		Usage:     "IM-billing-v2 auth login [--account NAME] [--device | --paste]",
+		ShortHelp: "authorize Google Calendar access and store the token",
+		Flags:     loginFS,
+		Exec:      runAuthLogin,
+	}
+
+	list := &ff.Command{
+		Name:      "list",
+		Usage:     "IM-billing-v2 auth list",
+		ShortHelp: "list accounts with a stored token",
+		Flags:     ff.NewFlagSet("list").SetParent(authFS),
+		Exec:      runAuthList,
+	}
+
+	logoutFS := ff.NewFlagSet("logout").SetParent(authFS)
+	localFlag = logoutFS.BoolLong("local", "delete the stored token without revoking it")
+
+	logout := &ff.Command{
+		Name:      "logout",
+		Usage:     "IM-billing-v2 auth logout [--local] [NAME]",
+		ShortHelp: "revoke and delete the stored token of an account (default: --account)",
+		Flags:     logoutFS,
+		Exec:      runAuthLogout,
+	}
+
+	status := &ff.Command{
+		Name:      "status",
+		Usage:     "IM-billing-v2 auth status [--account NAME]",
+		ShortHelp: "show expiry, granted scopes and refresh token of the stored token",
+		Flags:     ff.NewFlagSet("status").SetParent(authFS),
+		Exec:      runAuthStatus,
+	}
+
+	return &ff.Command{
+		Name:        "auth",
+		Usage:       "IM-billing-v2 auth COMMAND [FLAGS]",
+		ShortHelp:   "manage Google Calendar authorization",
+		Flags:       authFS,
+		Subcommands: []*ff.Command{login, status, list, logout},
+	}
+}
+
+// defaultConfigDir returns a default configuration directory, $XDG_CONFIG_HOME/IM-billing-v2 on Unix systems.
+func defaultConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, cache.AppName), nil
+}
+
+// newTokenStore returns the configured OAuth2 token storage backend for an account token at path, registering the
+// account whenever its token is saved.
+func newTokenStore(account, path string) oauth.TokenStore {
+	var store oauth.TokenStore
+
+	switch *tokenStore {
+	case tokenStoreEncrypted:
+		store = &oauth.EncryptedFileStore{Path: path, Passphrase: []byte(*tokenPassphrase)}
+	case tokenStoreKeyring:
+		store = &oauth.KeyringStore{Service: cache.AppName, User: account}
+	default:
+		store = &oauth.FileStore{Path: path}
+	}
+
+	return &registeredStore{TokenStore: store, registry: configPath(accountsFile), account: account}
+}
+
+// tokenLocation describes where the configured token storage keeps the token at path.
+func tokenLocation(path string) string {
+	if *tokenStore == tokenStoreKeyring {
+		return "system keyring"
+	}
+
+	return path
+}
+
+// calendarSource is an authorized Calendar API client and a calendar name to fetch events from.
+type calendarSource struct {
+	srv      *calendar.Service
+	calendar string
+	scope    string // event store scope of the authorized identity
+}
+
+// getReportSources authorizes the calendars taking part in a report: either a single calendar of the selected
+// account or service account, or calendars of several named accounts. Offline, calendars are billed from the event
+// store and nothing is authorized.
+func getReportSources(ctx context.Context) ([]calendarSource, error) {
+	if offlineFinal {
+		return offlineSources(), nil
+	}
+
+	if len(accountCalendarsFinal) == 0 {
+		srv, err := getCalendarService(ctx, *account, tokenFileFinal)
+		if err != nil {
+			return nil, err
+		}
+
+		return []calendarSource{{srv: srv, calendar: *calendarName, scope: sourceScope(*account)}}, nil
+	}
+
+	services := make(map[string]*calendar.Service)
+	sources := make([]calendarSource, 0, len(accountCalendarsFinal))
+
+	for _, ac := range accountCalendarsFinal {
+		srv, ok := services[ac.account]
+		if !ok {
+			var err error
+
+			if srv, err = getCalendarService(ctx, ac.account, accountTokenFile(ac.account)); err != nil {
+				return nil, fmt.Errorf("account %s: %w", ac.account, err)
+			}
+
+			services[ac.account] = srv
+		}
+
+		sources = append(sources, calendarSource{srv: srv, calendar: ac.calendar, scope: sourceScope(ac.account)})
+	}
+
+	return sources, nil
+}
+
+// offlineSources returns report sources without Calendar API clients, for billing stored events only.
+func offlineSources() []calendarSource {
+	if len(accountCalendarsFinal) == 0 {
+		return []calendarSource{{calendar: *calendarName, scope: sourceScope(*account)}}
+	}
+
+	sources := make([]calendarSource, 0, len(accountCalendarsFinal))
+	for _, ac := range accountCalendarsFinal {
+		sources = append(sources, calendarSource{calendar: ac.calendar, scope: sourceScope(ac.account)})
+	}
+
+	return sources
+}
+
+// sourceScope returns the event store scope of an account, or of a service account and its impersonated user, since
+// the same calendar name refers to different calendars of each.
+func sourceScope(account string) string {
+	if *serviceAccount != "" {
+		// An unreadable key fails authorization anyway, so its path tells keys apart instead
+		id, err := oauth.ServiceAccountEmail(*serviceAccount)
+		if err != nil {
+			id = *serviceAccount
+		}
+
+		return "service-account:" + id + ":" + *impersonate
+	}
+
+	return account
+}
+
+// getCalendarService returns a Calendar API client authorized for an account with a token at tokenPath.
+func getCalendarService(ctx context.Context, account, tokenPath string) (*calendar.Service, error) {
+	client, err := getCalendarClient(ctx, account, tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Calendar client: %w", err)
+	}
+
+	return srv, nil
+}
+
+// getCalendarClient returns an HTTP client authorized for read-only Calendar API access, either through a service
+// account key or through the stored account token, running the interactive login if needed.
+func getCalendarClient(ctx context.Context, account, tokenPath string) (*http.Client, error) {
+	if *serviceAccount != "" {
+		client, err := oauth.ServiceAccountClient(ctx, *serviceAccount, *impersonate, calendar.CalendarReadonlyScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authorize service account: %w", err)
+		}
+
+		return client, nil
+	}
+
+	config, err := getOAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve Calendar API user token
+	// Like auth login --paste, a login on a terminal also accepts a pasted redirect URL or authorization code
+	client, err := oauth.GetClient(ctx, config, newTokenStore(account, tokenPath), oauth.LoginOptions{
+		Paste: stdinTerminal(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token: %w", err)
+	}
+
+	return client, nil
+}
+
+// runAuthLogin runs an interactive OAuth2 login for the selected account and saves the resulting token.
+func runAuthLogin(ctx context.Context, _ []string) error {
+	config, err := getOAuthConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := oauth.Login(ctx, config, newTokenStore(*account, tokenFileFinal), oauth.LoginOptions{
+		Output: os.Stderr,
+		Device: *deviceFlag,
+		Paste:  *pasteFlag,
+	}); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	slog.Info("Login successful", "account", *account, "token", tokenLocation(tokenFileFinal))
+
+	return nil
+}
+
+// runAuthList lists accounts with a stored token, marking the selected account.
+func runAuthList(_ context.Context, _ []string) error {
+	accounts, err := loadAccounts(configPath(accountsFile))
+	if err != nil {
+		return err
+	}
+
+	// Tokens saved before accounts were introduced are not registered
+	if !slices.Contains(accounts, DefaultAccount) && *tokenStore != tokenStoreKeyring {
+		if _, err := os.Stat(accountTokenFile(DefaultAccount)); err == nil {
+			accounts = append(accounts, DefaultAccount)
+			slices.Sort(accounts)
+		}
+	}
+
+	if len(accounts) == 0 {
+		fmt.Println("No accounts, run auth login to add one.")
+
+		return nil
+	}
+
+	for _, name := range accounts {
+		marker := " "
+		if name == *account {
+			marker = "*"
+		}
+
+		fmt.Printf("%s %-20s %s\n", marker, name, tokenLocation(accountTokenFile(name)))
+	}
+
+	return nil
+}
+
+// runAuthStatus shows the stored token of the selected account, refreshing an expired access token to query
+// granted scopes.
+func runAuthStatus(ctx context.Context, _ []string) error {
+	config, err := getOAuthConfig()
+	if err != nil {
+		return err
+	}
+
+	store := newTokenStore(*account, tokenFileFinal)
+
+	stored, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("no usable token for account %s, run auth login: %w", *account, err)
+	}
+
+	fmt.Printf("Account:       %s\n", *account)
+	fmt.Printf("Token:         %s\n", tokenLocation(tokenFileFinal))
+
+	refresh := "missing, login required once the access token expires"
+	if stored.RefreshToken != "" {
+		refresh = "present"
+	}
+
+	fmt.Printf("Refresh token: %s\n", refresh)
+
+	tok, err := oauth.LoadToken(ctx, config, store)
+	if err != nil {
+		fmt.Printf("Access token:  expired at %s, refresh failed: %v\n", stored.Expiry.Local().Format(time.DateTime), err)
+
+		return nil
+	}
+
+	if !tok.Valid() {
+		fmt.Printf("Access token:  expired at %s, login required\n", tok.Expiry.Local().Format(time.DateTime))
+
+		return nil
+	}
+
+	if tok.Expiry.IsZero() {
+		fmt.Println("Access token:  no expiry")
+	} else {
+		fmt.Printf("Access token:  valid until %s\n", tok.Expiry.Local().Format(time.DateTime))
+	}
+
+	info, err := oauth.GetTokenInfo(ctx, tok)
+	if err != nil {
+		fmt.Printf("Scopes:        unknown: %v\n", err)
+
+		return nil
+	}
+
+	if info.Email != "" {
+		fmt.Printf("Email:         %s\n", info.Email)
+	}
+
+	fmt.Printf("Scopes:        %s\n", strings.Join(info.Scopes, " "))
+
+	return nil
+}
+
+// runAuthLogout revokes and deletes the stored token of the named account, or of the selected account.
+func runAuthLogout(ctx context.Context, args []string) error {
+	name, path := *account, tokenFileFinal
+
+	if len(args) > 0 {
+		if err := validateAccount(args[0]); err != nil {
+			return err
+		}
+
+		name, path = args[0], accountTokenFile(args[0])
+	}
+
+	store := newTokenStore(name, path)
+
+	// Revoke first, so a token that cannot be revoked is kept for another attempt
+	if tok, err := store.Load(); err == nil && !*localFlag {
+		if err := oauth.RevokeToken(ctx, tok); err != nil {
+			return fmt.Errorf("logout of account %s failed, use --local to delete the token anyway: %w", name, err)
+		}
+	}
+
+	if err := store.Delete(); err != nil {
+		return fmt.Errorf("logout of account %s failed: %w", name, err)
+	}
+
+	slog.Info("Logged out", "account", name)
+
+	return nil
+}
+
+// stdinTerminal reports whether standard input is a terminal, where an authorization code can be pasted.
+func stdinTerminal() bool {
+	fi, err := os.Stdin.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
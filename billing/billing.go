@@ -0,0 +1,234 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package billing aggregates calendar events into billed work days and computes billed amounts with weekend, public
+// holiday and after-hours surcharges. It holds no global state, so it can be embedded in other programs.
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DateLayout is a Time format parse layout of "YYYY-MM-DD", used for day keys.
+const DateLayout = "2006-01-02"
+
+var ErrEventTime = errors.New("unable to parse event time")
+
+// Query selects calendar events to bill.
+type Query struct {
+	Start, End       time.Time      // billing period, end exclusive
+	Location         *time.Location // time zone of day keys, time.Local if nil
+	Calendar         string         // calendar name, empty selects the primary calendar
+	Search           string         // description prefix of billed events, trimmed from descriptions
+	WorkHours        WorkHours      // working hours for after-hours detection, zero disables it
+	Retry            Retry          // retries of transient Calendar API failures, zero disables them
+	Logger           *slog.Logger   // debug logger of Calendar API requests, nil disables logging
+	IncludeRecurring bool           // also bill recurring event instances
+	Explain          bool           // record why each listed event was billed, excluded or skipped
+}
+
+// location returns the time zone of day keys.
+func (q Query) location() *time.Location {
+	if q.Location == nil {
+		return time.Local
+	}
+
+	return q.Location
+}
+
+// logger returns the query debug logger, discarding records if none is set.
+func (q Query) logger() *slog.Logger {
+	if q.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+
+	return q.Logger
+}
+
+// Event is a calendar event reduced to its billed parts, with RFC 3339 start and end times.
+type Event struct {
+	Description string
+	Start       string
+	End         string
+}
+
+// Day holds billed work on a single day. Descriptions are kept in a *strings.Builder so repeated same-day events
+// append in place instead of re-allocating a new string on every concatenation. A pointer is used because Day
+// values are copied on map read/write, and a strings.Builder must not be copied after first use.
+type Day struct {
+	desc       *strings.Builder
+	Hours      int // billed hours, partial hours of every event are rounded up
+	AfterHours int // billed hours outside working hours
+}
+
+// NewDay returns a day with a description and billed hours.
+func NewDay(description string, hours, afterHours int) Day {
+	b := &strings.Builder{}
+	b.WriteString(description)
+
+	return Day{desc: b, Hours: hours, AfterHours: afterHours}
+}
+
+// Description returns comma-separated descriptions of all events on the day.
+func (d Day) Description() string {
+	if d.desc == nil {
+		return ""
+	}
+
+	return d.desc.String()
+}
+
+// add accumulates hours and appends the description of another event or day.
+func (d Day) add(description string, hours, afterHours int) Day {
+	if d.desc == nil {
+		return NewDay(description, hours, afterHours)
+	}
+
+	d.Hours += hours
+	d.AfterHours += afterHours
+	d.desc.WriteString(", ")
+	d.desc.WriteString(description)
+
+	return d
+}
+
+// Holiday is a public holiday; same-day holidays share a single comma-separated description.
+type Holiday struct {
+	Description string
+}
+
+// Report holds billed work days of a query, keyed by "YYYY-MM-DD" dates, and public holidays in the period.
+type Report struct {
+	SyncedAt  time.Time // oldest sync of events billed from an event store, zero for a direct listing
+	Days      map[string]Day
+	Holidays  map[string]Holiday
+	Skipped   []error       // events that could not be billed
+	Explained []Explanation // decisions on listed events in listing order, only with Query.Explain
+	Query     Query
+}
+
+// NewReport returns an empty report for a query.
+func NewReport(q Query) *Report {
+	return &Report{
+		Query:    q,
+		Days:     make(map[string]Day),
+		Holidays: make(map[string]Holiday),
+	}
+}
+
+// billed is the billed day and hours of an event.
+type billed struct {
+	date       string
+	hours      int
+	afterHours int
+}
+
+// Add bills an event on its start day. Partial hours are billed as full hours. Events without a time component, such
+// as all-day events, cannot be billed and are reported as ErrEventTime.
+func (r *Report) Add(ev Event) error {
+	b, err := r.bill(ev)
+	if err != nil {
+		return err
+	}
+
+	r.Days[b.date] = r.Days[b.date].add(ev.Description, b.hours, b.afterHours)
+
+	return nil
+}
+
+// bill returns the billed day and hours of an event without adding it to the report.
+func (r *Report) bill(ev Event) (billed, error) {
+	loc := r.Query.location()
+
+	startTime, err := time.ParseInLocation(time.RFC3339, ev.Start, loc)
+	if err != nil {
+		return billed{}, fmt.Errorf("%w: event %q start time %q (all-day events without a time component are not "+
+			"supported)", ErrEventTime, ev.Description, ev.Start)
+	}
+
+	endTime, err := time.ParseInLocation(time.RFC3339, ev.End, loc)
+	if err != nil {
+		return billed{}, fmt.Errorf("%w: event %q end time %q (all-day events without a time component are not "+
+			"supported)", ErrEventTime, ev.Description, ev.End)
+	}
+
+	hours := int(math.Ceil(endTime.Sub(startTime).Hours()))
+
+	return billed{
+		date:       startTime.Format(DateLayout), // Starting time is an event key
+		hours:      hours,
+		afterHours: r.Query.WorkHours.AfterHours(startTime, endTime, hours),
+	}, nil
+}
+
+// Merge adds billed days and skipped events of another report, such as a report of another calendar. The merged
+// report is only as recent as its oldest synced part.
+func (r *Report) Merge(other *Report) {
+	for k, v := range other.Days {
+		r.Days[k] = r.Days[k].add(v.Description(), v.Hours, v.AfterHours)
+	}
+
+	r.Skipped = append(r.Skipped, other.Skipped...)
+	r.Explained = append(r.Explained, other.Explained...)
+
+	if !other.SyncedAt.IsZero() && (r.SyncedAt.IsZero() || other.SyncedAt.Before(r.SyncedAt)) {
+		r.SyncedAt = other.SyncedAt
+	}
+}
+
+// Dates returns sorted dates of billed days.
+func (r *Report) Dates() []string {
+	return sortedKeys(r.Days, func(string) bool { return true })
+}
+
+// TotalHours returns billed hours of all days.
+func (r *Report) TotalHours() int {
+	var total int
+
+	for _, v := range r.Days {
+		total += v.Hours
+	}
+
+	return total
+}
+
+// HolidayDates returns sorted dates of billed days on public holidays.
+func (r *Report) HolidayDates() []string {
+	return sortedKeys(r.Days, func(k string) bool {
+		_, ok := r.Holidays[k]
+
+		return ok
+	})
+}
+
+// FlaggedDates returns sorted dates of billed days on weekends or with after-hours work on regular days. Public
+// holidays are excluded, see HolidayDates.
+func (r *Report) FlaggedDates() []string {
+	return sortedKeys(r.Days, func(k string) bool {
+		c := r.Category(k)
+
+		return c == CategorySaturday || c == CategorySunday || (c == CategoryRegular && r.Days[k].AfterHours > 0)
+	})
+}
+
+// sortedKeys returns sorted keys of m accepted by keep.
+func sortedKeys[V any](m map[string]V, keep func(string) bool) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		if keep(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
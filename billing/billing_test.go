@@ -0,0 +1,217 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+// fixed start time used across rounding sub-tests.
+const roundingStart = "2024-01-15T09:00:00+00:00"
+
+// newReport returns an empty UTC report without after-hours detection.
+func newReport() *billing.Report {
+	return billing.NewReport(billing.Query{Location: time.UTC})
+}
+
+// mustAdd adds an event and fails the test on error.
+func mustAdd(t *testing.T, r *billing.Report, desc, start, end string) {
+	t.Helper()
+
+	if err := r.Add(billing.Event{Description: desc, Start: start, End: end}); err != nil {
+		t.Fatalf("Add(%q): %v", desc, err)
+	}
+}
+
+func TestReport_AddNewEvent(t *testing.T) {
+	r := newReport()
+
+	mustAdd(t, r, "Work on project", "2024-01-15T09:00:00+00:00", "2024-01-15T17:00:00+00:00")
+
+	if len(r.Days) != 1 {
+		t.Fatalf("expected 1 day, got %d", len(r.Days))
+	}
+
+	day, ok := r.Days["2024-01-15"]
+	if !ok {
+		t.Fatal("key 2024-01-15 not found in report days")
+	}
+
+	if day.Hours != 8 {
+		t.Errorf("Hours: got %d, want 8", day.Hours)
+	}
+
+	if day.Description() != "Work on project" {
+		t.Errorf("Description: got %q, want %q", day.Description(), "Work on project")
+	}
+}
+
+func TestReport_AddAccumulateSameDay(t *testing.T) {
+	r := newReport()
+
+	mustAdd(t, r, "Morning", "2024-01-15T09:00:00+00:00", "2024-01-15T13:00:00+00:00")
+	mustAdd(t, r, "Afternoon", "2024-01-15T14:00:00+00:00", "2024-01-15T18:00:00+00:00")
+
+	if len(r.Days) != 1 {
+		t.Fatalf("expected 1 day, got %d", len(r.Days))
+	}
+
+	day := r.Days["2024-01-15"]
+
+	if day.Hours != 8 {
+		t.Errorf("Hours: got %d, want 8", day.Hours)
+	}
+
+	if want := "Morning, Afternoon"; day.Description() != want {
+		t.Errorf("Description: got %q, want %q", day.Description(), want)
+	}
+}
+
+func TestReport_AddInvalidTime(t *testing.T) {
+	r := newReport()
+
+	for _, ev := range []billing.Event{
+		{Description: "Work", Start: "not-a-date", End: "2024-01-15T17:00:00+00:00"},
+		{Description: "Work", Start: "2024-01-15T09:00:00+00:00", End: "not-a-date"},
+		{Description: "All day", Start: "2024-01-15", End: "2024-01-16"},
+	} {
+		if err := r.Add(ev); !errors.Is(err, billing.ErrEventTime) {
+			t.Errorf("%+v: expected ErrEventTime, got %v", ev, err)
+		}
+	}
+
+	if len(r.Days) != 0 {
+		t.Errorf("expected no days for invalid events, got %d", len(r.Days))
+	}
+}
+
+func TestReport_AddHourRounding(t *testing.T) {
+	tests := []struct {
+		name      string
+		end       string
+		wantHours int
+	}{
+		// 2h00m
+		{"exact two hours", "2024-01-15T11:00:00+00:00", 2},
+		// 2h30m
+		{"half hour rounds up", "2024-01-15T11:30:00+00:00", 3},
+		// 2h01m
+		{"one minute rounds up", "2024-01-15T11:01:00+00:00", 3},
+		// 2h59m
+		{"below next hour rounds up", "2024-01-15T11:59:00+00:00", 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newReport()
+
+			mustAdd(t, r, "Work", roundingStart, tc.end)
+
+			if got := r.Days["2024-01-15"].Hours; got != tc.wantHours {
+				t.Errorf("Hours: got %d, want %d", got, tc.wantHours)
+			}
+		})
+	}
+}
+
+// An event crossing midnight must be bucketed under the start date.
+func TestReport_AddDateKeyFromStartNotEnd(t *testing.T) {
+	r := newReport()
+
+	mustAdd(t, r, "Late work", "2024-01-15T23:00:00+00:00", "2024-01-16T01:00:00+00:00")
+
+	if _, ok := r.Days["2024-01-15"]; !ok {
+		t.Error("expected key 2024-01-15 (start date) but it was absent")
+	}
+
+	if _, ok := r.Days["2024-01-16"]; ok {
+		t.Error("key 2024-01-16 (end date) must not be used as the day key")
+	}
+}
+
+func TestReport_AddAfterHours(t *testing.T) {
+	r := billing.NewReport(billing.Query{
+		Location:  time.UTC,
+		WorkHours: billing.WorkHours{Start: 9 * time.Hour, End: 17 * time.Hour},
+	})
+
+	mustAdd(t, r, "Late", "2024-01-15T16:00:00+00:00", "2024-01-15T19:00:00+00:00")
+
+	if got := r.Days["2024-01-15"].AfterHours; got != 2 {
+		t.Errorf("AfterHours: got %d, want 2", got)
+	}
+}
+
+func TestReport_Merge(t *testing.T) {
+	dst := billing.NewReport(billing.Query{})
+	dst.Days["2024-01-15"] = billing.NewDay("Client A", 4, 1)
+
+	src := billing.NewReport(billing.Query{})
+	src.Days["2024-01-15"] = billing.NewDay("Client B", 3, 2)
+	src.Days["2024-01-16"] = billing.NewDay("Client B", 2, 0)
+	src.Skipped = []error{billing.ErrEventTime}
+
+	dst.SyncedAt = time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	src.SyncedAt = time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)
+
+	dst.Merge(src)
+
+	if !dst.SyncedAt.Equal(src.SyncedAt) {
+		t.Errorf("SyncedAt: got %v, want the older %v", dst.SyncedAt, src.SyncedAt)
+	}
+
+	got := dst.Days["2024-01-15"]
+	if got.Hours != 7 || got.AfterHours != 3 || got.Description() != "Client A, Client B" {
+		t.Errorf("merged day: got %d/%d %q, want 7/3 %q", got.Hours, got.AfterHours, got.Description(),
+			"Client A, Client B")
+	}
+
+	if dst.Days["2024-01-16"].Hours != 2 {
+		t.Errorf("day only in src must be copied, got %+v", dst.Days["2024-01-16"])
+	}
+
+	if len(dst.Skipped) != 1 {
+		t.Errorf("skipped events must be merged, got %v", dst.Skipped)
+	}
+}
+
+func TestReport_Dates(t *testing.T) {
+	r := billing.NewReport(billing.Query{})
+	r.Days["2024-01-20"] = billing.NewDay("Saturday work", 4, 0)
+	r.Days["2024-01-15"] = billing.NewDay("Holiday work", 8, 0)
+	r.Days["2024-01-16"] = billing.NewDay("Late work", 8, 2)
+	r.Days["2024-01-17"] = billing.NewDay("Normal work", 8, 0)
+	r.Holidays["2024-01-15"] = billing.Holiday{Description: "Public Holiday"}
+	r.Holidays["2024-01-25"] = billing.Holiday{Description: "Another Holiday"}
+
+	if got, want := r.Dates(), []string{"2024-01-15", "2024-01-16", "2024-01-17", "2024-01-20"}; !slices.Equal(got, want) {
+		t.Errorf("Dates: got %v, want %v", got, want)
+	}
+
+	if got, want := r.HolidayDates(), []string{"2024-01-15"}; !slices.Equal(got, want) {
+		t.Errorf("HolidayDates: got %v, want %v", got, want)
+	}
+
+	if got, want := r.FlaggedDates(), []string{"2024-01-16", "2024-01-20"}; !slices.Equal(got, want) {
+		t.Errorf("FlaggedDates: got %v, want %v", got, want)
+	}
+
+	if got := r.TotalHours(); got != 28 {
+		t.Errorf("TotalHours: got %d, want 28", got)
+	}
+}
+
+func TestDay_ZeroValue(t *testing.T) {
+	var d billing.Day
+
+	if d.Description() != "" {
+		t.Errorf("zero Day description: got %q, want empty", d.Description())
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// PrimaryCalendar is the calendar ID of the user's primary calendar.
+const PrimaryCalendar = "primary"
+
+const (
+	// calendarMaxResults is a default maximum number of Google API results.
+	calendarMaxResults = 200
+
+	// eventMaxResults is the maximum page size of an event listing.
+	eventMaxResults = 2500
+)
+
+// eventItemFields are the event fields needed for billing and syncing; listings fetch and the event store keeps
+// only these.
+var eventItemFields = []string{
+	"id", "start", "end", "summary", "description", "recurringEventId", "status", "transparency", "attendees",
+}
+
+// eventFields is a partial response selector of an event listing, limited to eventItemFields and pagination.
+var eventFields = []googleapi.Field{
+	"nextPageToken",
+	"nextSyncToken",
+	googleapi.Field("items(" + strings.Join(eventItemFields, ",") + ")"),
+}
+
+var (
+	ErrCalendarList     = errors.New("unable to retrieve user's calendar list")
+	ErrCalendarNotFound = errors.New("calendar not found")
+	ErrEventList        = errors.New("unable to retrieve user's events")
+	ErrUnauthorized     = errors.New("not authorized for calendar API, login required")
+	ErrQuotaExceeded    = errors.New("calendar API quota exceeded")
+	ErrUnavailable      = errors.New("calendar API unavailable")
+)
+
+// Calendar API error reasons of exhausted request quota, reported with HTTP 403 or 429.
+var quotaReasons = []string{"rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded", "dailyLimitExceeded"}
+
+// CalendarID gets a Google calendar ID out of the symbolic query calendar name; an empty name selects the primary
+// calendar. A missing calendar is reported as ErrCalendarNotFound listing available calendar names.
+func CalendarID(ctx context.Context, srv *calendar.Service, q Query) (string, error) {
+	name := q.Calendar
+	if name == "" {
+		return PrimaryCalendar, nil
+	}
+
+	nextPageToken := ""
+
+	var availableNames []string
+
+	// Get calendar listing (paginated) and try to match name
+	for {
+		var listCal *calendar.CalendarList
+
+		err := q.Retry.do(ctx, func() (err error) {
+			listCal, err = srv.CalendarList.List().
+				MaxResults(calendarMaxResults).
+				PageToken(nextPageToken).
+				Context(ctx).
+				Do()
+
+			return err
+		})
+		if err != nil {
+			return "", apiError(ErrCalendarList, err)
+		}
+
+		q.logger().DebugContext(ctx, "Listed calendars page", "calendars", len(listCal.Items),
+			"more", listCal.NextPageToken != "")
+
+		// Match calendar name; collect all names for diagnostics
+		for _, item := range listCal.Items {
+			if item.Summary == name {
+				q.logger().DebugContext(ctx, "Resolved calendar", "calendar", name, "id", item.Id)
+
+				return item.Id, nil
+			}
+
+			availableNames = append(availableNames, item.Summary)
+		}
+
+		// Handle pagination
+		nextPageToken = listCal.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q, available calendars: %s", ErrCalendarNotFound, name,
+		strings.Join(availableNames, ", "))
+}
+
+// Fetch bills all calendar events of a query. Events that cannot be billed are listed in Report.Skipped.
+func Fetch(ctx context.Context, srv *calendar.Service, q Query) (*Report, error) {
+	items, err := ListEvents(ctx, srv, q)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReport(q)
+	r.addItems(items)
+
+	return r, nil
+}
+
+// ListEvents lists calendar events of the query calendar overlapping the query period, in start time order.
+func ListEvents(ctx context.Context, srv *calendar.Service, q Query) ([]*calendar.Event, error) {
+	calID, err := CalendarID(ctx, srv, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*calendar.Event
+
+	// Hoist loop-invariant values outside the pagination loop
+	timeMin := q.Start.Format(time.RFC3339)
+	timeMax := q.End.Format(time.RFC3339)
+
+	// Get all calendar events within specified date range
+	err = listEvents(ctx, srv, q, calID, func(call *calendar.EventsListCall) *calendar.EventsListCall {
+		return call.ShowDeleted(false).TimeMin(timeMin).TimeMax(timeMax).OrderBy("startTime")
+	}, func(events *calendar.Events) {
+		items = append(items, events.Items...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// listEvents lists single events of a calendar page by page, calling page for every page of results. Pages are as
+// large as allowed and only carry the fields in eventFields. A listing call is configured by setup; failed calls are
+// retried according to the query.
+func listEvents(ctx context.Context, srv *calendar.Service, q Query, calID string,
+	setup func(*calendar.EventsListCall) *calendar.EventsListCall, page func(*calendar.Events),
+) error {
+	nextPageToken := ""
+
+	for n := 1; ; n++ {
+		var events *calendar.Events
+
+		err := q.Retry.do(ctx, func() (err error) {
+			events, err = setup(srv.Events.List(calID)).
+				SingleEvents(true).
+				MaxResults(eventMaxResults).
+				Fields(eventFields...).
+				PageToken(nextPageToken).
+				Context(ctx).
+				Do()
+
+			return err
+		})
+		if err != nil {
+			// A calendar ID that is unknown or not shared with the account is reported as not found
+			if isStatus(err, http.StatusNotFound) {
+				return fmt.Errorf("%w: %q: %w", ErrCalendarNotFound, calID, err)
+			}
+
+			return apiError(ErrEventList, err)
+		}
+
+		q.logger().DebugContext(ctx, "Listed events page", "calendar", calID, "page", n, "events", len(events.Items),
+			"more", events.NextPageToken != "")
+
+		page(events)
+
+		// Handle pagination
+		nextPageToken = events.NextPageToken
+		if nextPageToken == "" {
+			return nil
+		}
+	}
+}
+
+// addItems bills calendar events selected by the report query; events that cannot be billed are listed in Skipped.
+// With Query.Explain, the decision on every event is recorded in Explained.
+func (r *Report) addItems(items []*calendar.Event) {
+	for _, item := range items {
+		ev, reason := r.Query.event(item)
+		if reason != "" {
+			r.Query.logger().Debug("Excluding event", "id", item.Id, "reason", reason)
+			r.explain(item, Explanation{Decision: DecisionExcluded, Reason: reason})
+
+			continue
+		}
+
+		b, err := r.bill(ev)
+		if err != nil {
+			r.Skipped = append(r.Skipped, err)
+			r.explain(item, Explanation{Decision: DecisionSkipped, Reason: skippedReason(item, err)})
+
+			continue
+		}
+
+		r.Days[b.date] = r.Days[b.date].add(ev.Description, b.hours, b.afterHours)
+		r.explain(item, Explanation{
+			Decision:    DecisionBilled,
+			Description: ev.Description,
+			Date:        b.date,
+			Billed:      b.hours,
+			AfterHours:  b.afterHours,
+		})
+	}
+}
+
+// event converts a calendar event to a billed event, returning why the event is not selected by the query instead.
+func (q Query) event(item *calendar.Event) (Event, string) {
+	// Deleted events only show up in incremental listings
+	if item.Status == eventCancelled {
+		return Event{}, "cancelled event"
+	}
+
+	// Don't parse event if it's recurring event
+	if !q.IncludeRecurring && item.RecurringEventId != "" {
+		return Event{}, "recurring event instance, recurring events are not included"
+	}
+
+	// Start/End are *EventDateTime pointers; skip rather than panic if absent
+	if item.Start == nil || item.End == nil {
+		return Event{}, "missing start or end time"
+	}
+
+	start := item.Start.DateTime
+	if start == "" {
+		start = item.Start.Date
+	}
+
+	end := item.End.DateTime
+	if end == "" {
+		end = item.End.Date
+	}
+
+	// Trim event description/summary whitespace
+	desc := strings.TrimSpace(item.Description)
+	if desc == "" {
+		desc = strings.TrimSpace(item.Summary)
+	}
+
+	// Match prefix string if requested
+	if q.Search != "" {
+		if !strings.HasPrefix(desc, q.Search) {
+			return Event{}, fmt.Sprintf("description does not start with %q", q.Search)
+		}
+
+		desc = strings.TrimSpace(strings.TrimPrefix(desc, q.Search))
+	}
+
+	return Event{Description: desc, Start: start, End: end}, ""
+}
+
+// apiError wraps a failed Calendar API call with its operation and, when known, its error category: ErrUnauthorized,
+// ErrQuotaExceeded or ErrUnavailable.
+func apiError(op, err error) error {
+	if category := apiErrorCategory(err); category != nil {
+		return fmt.Errorf("%w: %w: %w", op, category, err)
+	}
+
+	return fmt.Errorf("%w: %w", op, err)
+}
+
+// apiErrorCategory returns the category of a Calendar API error, or nil if unknown.
+func apiErrorCategory(err error) error {
+	// Cancellation is not an API failure, the caller knows why its context is done
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	// Token refresh failures surface from the authorized transport, e.g. a revoked refresh token
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return ErrUnauthorized
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return ErrUnavailable
+		}
+
+		return nil
+	}
+
+	switch {
+	case apiErr.Code == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case apiErr.Code == http.StatusTooManyRequests:
+		return ErrQuotaExceeded
+	case apiErr.Code == http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if slices.Contains(quotaReasons, item.Reason) {
+				return ErrQuotaExceeded
+			}
+		}
+
+		return ErrUnauthorized
+	case apiErr.Code >= http.StatusInternalServerError:
+		return ErrUnavailable
+	}
+
+	return nil
+}
+
+// isStatus reports whether err is a Calendar API error with an HTTP status code.
+func isStatus(err error, code int) bool {
+	var apiErr *googleapi.Error
+
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
@@ -0,0 +1,465 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// newService returns a Calendar API client for a fake server serving requests with handler.
+func newService(tb testing.TB, handler http.Handler) *calendar.Service {
+	tb.Helper()
+
+	srv := httptest.NewServer(handler)
+	tb.Cleanup(srv.Close)
+
+	svc, err := calendar.NewService(context.Background(), option.WithEndpoint(srv.URL),
+		option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		tb.Fatalf("calendar.NewService: %v", err)
+	}
+
+	return svc
+}
+
+// newCalendarService returns a Calendar API client talking to a fake server with a single "Work" calendar. Its
+// events are paginated over two pages.
+func newCalendarService(t *testing.T) *calendar.Service {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/me/calendarList", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items":[{"id":"personal-id","summary":"Personal"},{"id":"work-id","summary":"Work"}]}`))
+	})
+	mux.HandleFunc("/calendars/work-id/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("pageToken") == "" {
+			_, _ = w.Write([]byte(`{"nextPageToken":"page-2","items":[` +
+				`{"summary":"ACME Design review","start":{"dateTime":"2024-01-15T09:00:00Z"},"end":{"dateTime":"2024-01-15T11:30:00Z"}},` +
+				`{"summary":"Lunch","start":{"dateTime":"2024-01-15T12:00:00Z"},"end":{"dateTime":"2024-01-15T13:00:00Z"}},` +
+				`{"summary":"ACME Standup","recurringEventId":"daily","start":{"dateTime":"2024-01-16T09:00:00Z"},"end":{"dateTime":"2024-01-16T09:15:00Z"}}` +
+				`]}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"items":[` +
+			`{"summary":"ACME Release","start":{"dateTime":"2024-01-16T18:00:00Z"},"end":{"dateTime":"2024-01-16T20:00:00Z"}},` +
+			`{"summary":"ACME Offsite","start":{"date":"2024-01-17"},"end":{"date":"2024-01-18"}}` +
+			`]}`))
+	})
+
+	return newService(t, mux)
+}
+
+func TestFetch(t *testing.T) {
+	srv := newCalendarService(t)
+
+	r, err := billing.Fetch(context.Background(), srv, billing.Query{
+		Start:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:       time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Location:  time.UTC,
+		Calendar:  "Work",
+		Search:    "ACME",
+		WorkHours: billing.WorkHours{Start: 9 * time.Hour, End: 17 * time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if got := r.Days["2024-01-15"]; got.Hours != 3 || got.Description() != "Design review" {
+		t.Errorf("2024-01-15: got %dh %q, want 3h %q", got.Hours, got.Description(), "Design review")
+	}
+
+	// Recurring standup is excluded, the release on the second page is after hours
+	if got := r.Days["2024-01-16"]; got.Hours != 2 || got.AfterHours != 2 || got.Description() != "Release" {
+		t.Errorf("2024-01-16: got %dh/%dh %q, want 2h/2h %q", got.Hours, got.AfterHours, got.Description(), "Release")
+	}
+
+	// All-day events cannot be billed
+	if len(r.Skipped) != 1 || !errors.Is(r.Skipped[0], billing.ErrEventTime) {
+		t.Errorf("Skipped: got %v, want one ErrEventTime", r.Skipped)
+	}
+}
+
+func TestFetch_IncludeRecurring(t *testing.T) {
+	srv := newCalendarService(t)
+
+	r, err := billing.Fetch(context.Background(), srv, billing.Query{
+		Location:         time.UTC,
+		Calendar:         "Work",
+		Search:           "ACME",
+		IncludeRecurring: true,
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if got := r.Days["2024-01-16"]; got.Hours != 3 || got.Description() != "Standup, Release" {
+		t.Errorf("2024-01-16: got %dh %q, want 3h %q", got.Hours, got.Description(), "Standup, Release")
+	}
+}
+
+func TestFetch_DebugLog(t *testing.T) {
+	srv := newCalendarService(t)
+
+	var buf bytes.Buffer
+
+	_, err := billing.Fetch(context.Background(), srv, billing.Query{
+		Calendar: "Work",
+		Logger:   slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	out := buf.String()
+
+	if !strings.Contains(out, "calendar=Work id=work-id") {
+		t.Errorf("calendar lookup not logged:\n%s", out)
+	}
+
+	if got := strings.Count(out, "Listed events page"); got != 2 {
+		t.Errorf("got %d logged event pages, want 2:\n%s", got, out)
+	}
+}
+
+func TestFetch_CalendarNotFound(t *testing.T) {
+	srv := newCalendarService(t)
+
+	_, err := billing.Fetch(context.Background(), srv, billing.Query{Calendar: "Missing"})
+	if !errors.Is(err, billing.ErrCalendarNotFound) {
+		t.Fatalf("error: got %v, want ErrCalendarNotFound", err)
+	}
+}
+
+// newFailingService returns a Calendar API client for a fake server failing every request with an HTTP status and a
+// JSON error reason.
+func newFailingService(t *testing.T, status int, reason string) *calendar.Service {
+	t.Helper()
+
+	return newService(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, `{"error":{"code":%d,"message":"failed","errors":[{"reason":%q}]}}`, status, reason)
+	}))
+}
+
+func TestFetch_APIErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		reason   string
+		calendar string
+		want     error
+	}{
+		{"unauthorized", http.StatusUnauthorized, "authError", "", billing.ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, "insufficientPermissions", "", billing.ErrUnauthorized},
+		{"rate limited", http.StatusForbidden, "rateLimitExceeded", "", billing.ErrQuotaExceeded},
+		{"too many requests", http.StatusTooManyRequests, "rateLimitExceeded", "", billing.ErrQuotaExceeded},
+		{"server error", http.StatusServiceUnavailable, "backendError", "", billing.ErrUnavailable},
+		{"unknown calendar ID", http.StatusNotFound, "notFound", "", billing.ErrCalendarNotFound},
+		{"calendar list", http.StatusUnauthorized, "authError", "Work", billing.ErrCalendarList},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFailingService(t, tc.status, tc.reason)
+
+			_, err := billing.Fetch(context.Background(), srv, billing.Query{Calendar: tc.calendar})
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("error: got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFetch_DroppedConnection(t *testing.T) {
+	// The server drops every connection without a response, like an unreachable API
+	svc := newService(t, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	_, err := billing.Fetch(context.Background(), svc, billing.Query{})
+	if !errors.Is(err, billing.ErrUnavailable) {
+		t.Fatalf("error: got %v, want ErrUnavailable", err)
+	}
+}
+
+// fixtureServer replays a recorded event listing (testdata/events.json) over as many pages as requested, repeating the
+// recorded events on consecutive days. Like the Calendar API, it honours maxResults and partial response fields, and
+// it counts requests and response bytes so payload and round-trip regressions are visible.
+type fixtureServer struct {
+	listing  map[string]any // recorded listing without items
+	items    []map[string]any
+	total    int // listed events
+	requests atomic.Int64
+	bytes    atomic.Int64
+
+	mu    sync.Mutex
+	query url.Values // query of the last request
+}
+
+// newFixtureServer returns a Calendar API client for a fixture server listing total events.
+func newFixtureServer(tb testing.TB, total int) (*calendar.Service, *fixtureServer) {
+	tb.Helper()
+
+	data, err := os.ReadFile("testdata/events.json")
+	if err != nil {
+		tb.Fatalf("ReadFile: %v", err)
+	}
+
+	var recorded struct {
+		Items []map[string]any `json:"items"`
+	}
+
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		tb.Fatalf("Unmarshal: %v", err)
+	}
+
+	fs := &fixtureServer{items: recorded.Items, total: total}
+	if err := json.Unmarshal(data, &fs.listing); err != nil {
+		tb.Fatalf("Unmarshal: %v", err)
+	}
+
+	delete(fs.listing, "items")
+
+	return newService(tb, fs), fs
+}
+
+// ServeHTTP implements http.Handler.
+func (fs *fixtureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	fs.mu.Lock()
+	fs.query = query
+	fs.mu.Unlock()
+
+	offset, _ := strconv.Atoi(query.Get("pageToken"))
+
+	// Calendar API defaults to 250 events per page and allows up to 2500
+	size, err := strconv.Atoi(query.Get("maxResults"))
+	if err != nil || size <= 0 {
+		size = 250
+	}
+
+	size = min(size, 2500)
+	end := min(offset+size, fs.total)
+
+	items := make([]any, 0, end-offset)
+	for i := offset; i < end; i++ {
+		items = append(items, fs.item(i))
+	}
+
+	resp := make(map[string]any, len(fs.listing)+2)
+	for k, v := range fs.listing {
+		resp[k] = v
+	}
+
+	resp["items"] = items
+
+	if end < fs.total {
+		resp["nextPageToken"] = strconv.Itoa(end)
+	} else {
+		resp["nextSyncToken"] = "fixture"
+	}
+
+	if fields := query.Get("fields"); fields != "" {
+		resp = selectFields(resp, fields)
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	fs.requests.Add(1)
+	fs.bytes.Add(int64(len(body)))
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// item returns the i-th listed event: a recorded event with a unique ID, moved by a day for each repetition.
+func (fs *fixtureServer) item(i int) map[string]any {
+	rec := fs.items[i%len(fs.items)]
+	days := i / len(fs.items)
+
+	item := make(map[string]any, len(rec))
+	for k, v := range rec {
+		item[k] = v
+	}
+
+	item["id"] = fmt.Sprintf("%v-%d", rec["id"], i)
+
+	for _, k := range []string{"start", "end"} {
+		dt, ok := rec[k].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		moved := make(map[string]any, len(dt))
+		for kk, v := range dt {
+			moved[kk] = v
+		}
+
+		if s, ok := dt["dateTime"].(string); ok {
+			t, _ := time.Parse(time.RFC3339, s)
+			moved["dateTime"] = t.AddDate(0, 0, days).Format(time.RFC3339)
+		}
+
+		if s, ok := dt["date"].(string); ok {
+			t, _ := time.Parse(billing.DateLayout, s)
+			moved["date"] = t.AddDate(0, 0, days).Format(billing.DateLayout)
+		}
+
+		item[k] = moved
+	}
+
+	return item
+}
+
+// selectFields applies a partial response selector such as "nextPageToken,items(id,start)" to a JSON object.
+func selectFields(v map[string]any, fields string) map[string]any {
+	out := make(map[string]any)
+
+	for _, f := range splitFields(fields) {
+		name, sub, nested := strings.Cut(f, "(")
+
+		val, ok := v[name]
+		if !ok {
+			continue
+		}
+
+		if nested {
+			sub = strings.TrimSuffix(sub, ")")
+
+			switch x := val.(type) {
+			case map[string]any:
+				val = selectFields(x, sub)
+			case []any:
+				selected := make([]any, 0, len(x))
+				for _, e := range x {
+					if m, ok := e.(map[string]any); ok {
+						selected = append(selected, selectFields(m, sub))
+					}
+				}
+
+				val = selected
+			}
+		}
+
+		out[name] = val
+	}
+
+	return out
+}
+
+// splitFields splits a partial response selector on commas outside of parentheses.
+func splitFields(fields string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i, c := range fields {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, fields[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, fields[start:])
+}
+
+func TestFetch_PartialResponse(t *testing.T) {
+	srv, fs := newFixtureServer(t, 10)
+
+	r, err := billing.Fetch(context.Background(), srv, billing.Query{
+		Location: time.FixedZone("CET", 3600),
+		Search:   "ACME",
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if got := fs.query.Get("maxResults"); got != "2500" {
+		t.Errorf("maxResults: got %q, want 2500", got)
+	}
+
+	if got := fs.query.Get("fields"); !strings.Contains(got, "items(") || !strings.Contains(got, "nextPageToken") {
+		t.Errorf("fields: got %q, want a partial response with items and nextPageToken", got)
+	}
+
+	if fs.requests.Load() != 1 {
+		t.Errorf("requests: got %d, want 1", fs.requests.Load())
+	}
+
+	// Selected fields still carry descriptions and recurring event IDs: the recurring standup is left out and
+	// descriptions take precedence over summaries
+	const want = "Design review of the billing export, Release of version 2.4"
+
+	for _, k := range []string{"2024-01-01", "2024-01-02"} {
+		if got := r.Days[k]; got.Hours != 5 || got.Description() != want {
+			t.Errorf("%s: got %dh %q, want 5h %q", k, got.Hours, got.Description(), want)
+		}
+	}
+
+	// All-day offsites cannot be billed
+	if len(r.Skipped) != 2 {
+		t.Errorf("Skipped: got %v, want two all-day events", r.Skipped)
+	}
+}
+
+// BenchmarkFetch bills recorded events listed by a fixture server. Besides time and allocations, it reports Calendar
+// API requests and response bytes per billed listing.
+func BenchmarkFetch(b *testing.B) {
+	for _, total := range []int{250, 2500, 10000} {
+		b.Run(strconv.Itoa(total), func(b *testing.B) {
+			srv, fs := newFixtureServer(b, total)
+			q := billing.Query{Location: time.FixedZone("CET", 3600), Search: "ACME"}
+
+			for b.Loop() {
+				if _, err := billing.Fetch(context.Background(), srv, q); err != nil {
+					b.Fatalf("Fetch: %v", err)
+				}
+			}
+
+			b.ReportMetric(float64(fs.requests.Load())/float64(b.N), "requests/op")
+			b.ReportMetric(float64(fs.bytes.Load())/float64(b.N), "resp-B/op")
+		})
+	}
+}
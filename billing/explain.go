@@ -0,0 +1,116 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Decision is what happened to a listed calendar event.
+type Decision int
+
+const (
+	DecisionBilled   Decision = iota // billed on its start day
+	DecisionExcluded                 // not selected by the query
+	DecisionSkipped                  // selected, but cannot be billed and listed in Report.Skipped
+)
+
+// String returns a decision name.
+func (d Decision) String() string {
+	switch d {
+	case DecisionBilled:
+		return "billed"
+	case DecisionExcluded:
+		return "excluded"
+	case DecisionSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// Explanation tells why a listed calendar event was billed, excluded or skipped, so a billed amount can be audited
+// without going through the calendar.
+type Explanation struct {
+	Start       time.Time     // event start, zero if it cannot be parsed
+	ID          string        // calendar event ID
+	Summary     string        // event title as shown in the calendar
+	Description string        // billed description, empty unless billed
+	Date        string        // billed day, empty unless billed
+	Reason      string        // why the event was excluded or skipped, or how it was changed when billed
+	Raw         time.Duration // event duration in the calendar, zero if times cannot be parsed
+	Billed      int           // billed hours
+	AfterHours  int           // billed hours outside working hours
+	Decision    Decision
+}
+
+// explain records a decision on a listed event when the query asks for explanations, completing it with the raw
+// event fields.
+func (r *Report) explain(item *calendar.Event, e Explanation) {
+	if !r.Query.Explain {
+		return
+	}
+
+	loc := r.Query.location()
+
+	e.ID = item.Id
+	e.Summary = item.Summary
+
+	if start, err := eventTime(item.Start, loc); err == nil {
+		e.Start = start.In(loc)
+
+		if end, err := eventTime(item.End, loc); err == nil {
+			e.Raw = end.Sub(start)
+		}
+	}
+
+	if e.Decision == DecisionBilled {
+		e.Reason = r.billedReason(item, e)
+	}
+
+	r.Explained = append(r.Explained, e)
+}
+
+// billedReason describes how a billed event was changed: a trimmed search prefix, a summary used for a missing
+// description, hours rounded up and hours outside working hours.
+func (r *Report) billedReason(item *calendar.Event, e Explanation) string {
+	var changes []string
+
+	if strings.TrimSpace(item.Description) == "" {
+		changes = append(changes, "summary used as description")
+	}
+
+	if r.Query.Search != "" {
+		changes = append(changes, fmt.Sprintf("prefix %q trimmed", r.Query.Search))
+	}
+
+	if e.Raw < time.Duration(e.Billed)*time.Hour {
+		changes = append(changes, fmt.Sprintf("rounded up from %v", e.Raw))
+	}
+
+	if e.AfterHours > 0 {
+		changes = append(changes, fmt.Sprintf("%dh outside working hours", e.AfterHours))
+	}
+
+	if len(changes) == 0 {
+		return "billed as listed"
+	}
+
+	return strings.Join(changes, ", ")
+}
+
+// skippedReason describes why a selected event cannot be billed.
+func skippedReason(item *calendar.Event, err error) string {
+	if errors.Is(err, ErrEventTime) && (item.Start.DateTime == "" || item.End.DateTime == "") {
+		return "all-day event without a time component"
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+func TestFetch_Explain(t *testing.T) {
+	srv := newCalendarService(t)
+
+	r, err := billing.Fetch(context.Background(), srv, billing.Query{
+		Location:  time.UTC,
+		Calendar:  "Work",
+		Search:    "ACME",
+		WorkHours: billing.WorkHours{Start: 9 * time.Hour, End: 17 * time.Hour},
+		Explain:   true,
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	want := []struct {
+		summary  string
+		decision billing.Decision
+		reason   string
+		raw      time.Duration
+		billed   int
+	}{
+		{"ACME Design review", billing.DecisionBilled, "rounded up from 2h30m0s", 150 * time.Minute, 3},
+		{"Lunch", billing.DecisionExcluded, `does not start with "ACME"`, time.Hour, 0},
+		{"ACME Standup", billing.DecisionExcluded, "recurring event", 15 * time.Minute, 0},
+		{"ACME Release", billing.DecisionBilled, "2h outside working hours", 2 * time.Hour, 2},
+		{"ACME Offsite", billing.DecisionSkipped, "all-day event", 24 * time.Hour, 0},
+	}
+
+	if len(r.Explained) != len(want) {
+		t.Fatalf("Explained: got %d events, want %d", len(r.Explained), len(want))
+	}
+
+	for i, w := range want {
+		got := r.Explained[i]
+
+		if got.Summary != w.summary || got.Decision != w.decision || !strings.Contains(got.Reason, w.reason) {
+			t.Errorf("event %d: got %q %v %q, want %q %v containing %q", i, got.Summary, got.Decision, got.Reason,
+				w.summary, w.decision, w.reason)
+		}
+
+		if got.Raw != w.raw || got.Billed != w.billed {
+			t.Errorf("%s: got %v raw and %dh billed, want %v and %dh", w.summary, got.Raw, got.Billed, w.raw, w.billed)
+		}
+	}
+}
+
+func TestFetch_NoExplain(t *testing.T) {
+	srv := newCalendarService(t)
+
+	r, err := billing.Fetch(context.Background(), srv, billing.Query{Calendar: "Work"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if len(r.Explained) != 0 {
+		t.Errorf("Explained: got %d events without Explain, want none", len(r.Explained))
+	}
+}
+
+func TestDecision_String(t *testing.T) {
+	for d, want := range map[billing.Decision]string{
+		billing.DecisionBilled:   "billed",
+		billing.DecisionExcluded: "excluded",
+		billing.DecisionSkipped:  "skipped",
+	} {
+		if got := d.String(); got != want {
+			t.Errorf("%d: got %q, want %q", d, got, want)
+		}
+	}
+}
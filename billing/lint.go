@@ -0,0 +1,257 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Check is a calendar hygiene check of Lint.
+type Check string
+
+const (
+	CheckOverlap          Check = "overlap"           // billed events overlapping in time are billed twice
+	CheckEmptyDescription Check = "empty-description" // billed events without a description after prefix trimming
+	CheckDuration         Check = "duration"          // billed events ending at or before their start
+	CheckMaxLength        Check = "max-length"        // billed events longer than LintOptions.MaxEventLength
+	CheckDailyCap         Check = "daily-cap"         // days billed above LintOptions.DailyCap hours
+	CheckNearMissPrefix   Check = "near-miss-prefix"  // unbilled events almost matching the search prefix
+)
+
+// prefixPunctuation is trimmed from a search prefix to find near misses, such as "CLIENT " for "CLIENT:".
+const prefixPunctuation = " \t:;,.-_/|#"
+
+// LintOptions configures limits of Lint; a zero limit disables its check.
+type LintOptions struct {
+	MaxEventLength time.Duration // longest plausible billed event
+	DailyCap       int           // most plausible billed hours per day
+}
+
+// Finding is a calendar problem that would corrupt billing.
+type Finding struct {
+	Start   time.Time // start of the first event concerned, or of the day
+	Check   Check
+	Message string
+}
+
+// String returns a finding as a single line.
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s: %s", f.Start.Format("2006-01-02 15:04"), f.Check, f.Message)
+}
+
+// lintEvent is an event selected by the query with parsed times.
+type lintEvent struct {
+	start, end time.Time
+	item       *calendar.Event
+	ev         Event
+}
+
+// Lint checks events of the query, as listed by ListEvents or Store.Events, for problems that would corrupt billing.
+// Findings are sorted by start time. Events that cannot be billed at all, such as all-day events, are left to
+// Report.Skipped.
+func Lint(q Query, items []*calendar.Event, opts LintOptions) []Finding {
+	var (
+		findings []Finding
+		events   []lintEvent
+	)
+
+	loc := q.location()
+
+	for _, item := range items {
+		ev, reason := q.event(item)
+		if reason != "" {
+			if f, ok := q.nearMiss(item, loc); ok {
+				findings = append(findings, f)
+			}
+
+			continue
+		}
+
+		start, err := time.ParseInLocation(time.RFC3339, ev.Start, loc)
+		if err != nil {
+			continue
+		}
+
+		end, err := time.ParseInLocation(time.RFC3339, ev.End, loc)
+		if err != nil {
+			continue
+		}
+
+		events = append(events, lintEvent{start: start.In(loc), end: end.In(loc), item: item, ev: ev})
+	}
+
+	slices.SortStableFunc(events, func(a, b lintEvent) int { return a.start.Compare(b.start) })
+
+	findings = append(findings, lintEvents(events, opts)...)
+	findings = append(findings, lintOverlaps(events)...)
+	findings = append(findings, lintDailyCap(events, opts, loc)...)
+
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.Check, b.Check))
+	})
+
+	return findings
+}
+
+// lintEvents checks billed events one by one: descriptions and durations.
+func lintEvents(events []lintEvent, opts LintOptions) []Finding {
+	var findings []Finding
+
+	for _, e := range events {
+		d := e.end.Sub(e.start)
+
+		if e.ev.Description == "" {
+			findings = append(findings, Finding{
+				Start:   e.start,
+				Check:   CheckEmptyDescription,
+				Message: fmt.Sprintf("event %q has no description after prefix trimming", e.item.Summary),
+			})
+		}
+
+		if d <= 0 {
+			findings = append(findings, Finding{
+				Start:   e.start,
+				Check:   CheckDuration,
+				Message: fmt.Sprintf("event %q lasts %v", e.item.Summary, d),
+			})
+		}
+
+		if opts.MaxEventLength > 0 && d > opts.MaxEventLength {
+			findings = append(findings, Finding{
+				Start:   e.start,
+				Check:   CheckMaxLength,
+				Message: fmt.Sprintf("event %q lasts %v, longer than %v", e.item.Summary, d, opts.MaxEventLength),
+			})
+		}
+	}
+
+	return findings
+}
+
+// lintOverlaps finds billed events starting before an earlier event ends, since both are billed in full. Events are
+// sorted by start time.
+func lintOverlaps(events []lintEvent) []Finding {
+	var (
+		findings []Finding
+		latest   *lintEvent // earlier event ending last
+	)
+
+	for i := range events {
+		e := &events[i]
+		if e.end.Sub(e.start) <= 0 {
+			continue
+		}
+
+		if latest != nil && e.start.Before(latest.end) {
+			end := e.end
+			if latest.end.Before(end) {
+				end = latest.end
+			}
+
+			findings = append(findings, Finding{
+				Start: e.start,
+				Check: CheckOverlap,
+				Message: fmt.Sprintf("event %q overlaps %q by %v, both are billed", e.item.Summary,
+					latest.item.Summary, end.Sub(e.start)),
+			})
+		}
+
+		if latest == nil || e.end.After(latest.end) {
+			latest = e
+		}
+	}
+
+	return findings
+}
+
+// lintDailyCap finds days billed above the daily cap, billing hours like Report.Add.
+func lintDailyCap(events []lintEvent, opts LintOptions, loc *time.Location) []Finding {
+	if opts.DailyCap <= 0 {
+		return nil
+	}
+
+	hours := make(map[string]int)
+
+	for _, e := range events {
+		if d := e.end.Sub(e.start); d > 0 {
+			hours[e.start.Format(DateLayout)] += int(math.Ceil(d.Hours()))
+		}
+	}
+
+	var findings []Finding
+
+	for _, k := range sortedKeys(hours, func(k string) bool { return hours[k] > opts.DailyCap }) {
+		day, _ := time.ParseInLocation(DateLayout, k, loc)
+		findings = append(findings, Finding{
+			Start:   day,
+			Check:   CheckDailyCap,
+			Message: fmt.Sprintf("%dh billed, more than %dh", hours[k], opts.DailyCap),
+		})
+	}
+
+	return findings
+}
+
+// nearMiss reports an unbilled event whose description almost starts with the search prefix: the same words with
+// different case or punctuation, such as "CLIENT " or "client:" for "CLIENT:".
+func (q Query) nearMiss(item *calendar.Event, loc *time.Location) (Finding, bool) {
+	core := strings.TrimRight(q.Search, prefixPunctuation)
+	if core == "" || item.Status == eventCancelled || (!q.IncludeRecurring && item.RecurringEventId != "") {
+		return Finding{}, false
+	}
+
+	desc := strings.TrimSpace(item.Description)
+	if desc == "" {
+		desc = strings.TrimSpace(item.Summary)
+	}
+
+	if strings.HasPrefix(desc, q.Search) {
+		return Finding{}, false
+	}
+
+	n, ok := foldPrefix(desc, core)
+	if !ok {
+		return Finding{}, false
+	}
+
+	// A longer word, such as "CLIENTELE" for "CLIENT:", is not a near miss
+	if r, size := utf8.DecodeRuneInString(desc[n:]); size > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		return Finding{}, false
+	}
+
+	start, _ := eventTime(item.Start, loc)
+
+	return Finding{
+		Start:   start.In(loc),
+		Check:   CheckNearMissPrefix,
+		Message: fmt.Sprintf("event %q is not billed, its description almost starts with %q", item.Summary, q.Search),
+	}, true
+}
+
+// foldPrefix reports whether s starts with prefix under Unicode case folding, comparing rune by rune, and returns the
+// length of the matching prefix of s in bytes, which may differ from the length of prefix.
+func foldPrefix(s, prefix string) (int, bool) {
+	n := 0
+
+	for _, want := range prefix {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if size == 0 || !strings.EqualFold(string(r), string(want)) {
+			return 0, false
+		}
+
+		n += size
+	}
+
+	return n, true
+}
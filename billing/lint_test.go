@@ -0,0 +1,101 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"google.golang.org/api/calendar/v3"
+)
+
+// timedEvent returns a calendar event with a description and RFC 3339 start and end times.
+func timedEvent(desc, start, end string) *calendar.Event {
+	return &calendar.Event{
+		Summary: desc,
+		Start:   &calendar.EventDateTime{DateTime: start},
+		End:     &calendar.EventDateTime{DateTime: end},
+	}
+}
+
+func TestLint(t *testing.T) {
+	items := []*calendar.Event{
+		timedEvent("CLIENT: Design", "2024-01-15T09:00:00Z", "2024-01-15T11:00:00Z"),
+		timedEvent("CLIENT: Review", "2024-01-15T10:30:00Z", "2024-01-15T12:00:00Z"),
+		timedEvent("CLIENT:   ", "2024-01-16T09:00:00Z", "2024-01-16T10:00:00Z"),
+		timedEvent("CLIENT: Backwards", "2024-01-17T10:00:00Z", "2024-01-17T09:00:00Z"),
+		timedEvent("CLIENT: Migration", "2024-01-18T08:00:00Z", "2024-01-18T22:00:00Z"),
+		timedEvent("CLIENT Support", "2024-01-19T09:00:00Z", "2024-01-19T10:00:00Z"),
+		timedEvent("client: Call", "2024-01-19T11:00:00Z", "2024-01-19T12:00:00Z"),
+		timedEvent("CLIENTELE review", "2024-01-19T13:00:00Z", "2024-01-19T14:00:00Z"),
+		timedEvent("Lunch", "2024-01-19T12:00:00Z", "2024-01-19T13:00:00Z"),
+		{Summary: "CLIENT: Offsite", Start: &calendar.EventDateTime{Date: "2024-01-20"},
+			End: &calendar.EventDateTime{Date: "2024-01-21"}},
+	}
+
+	findings := billing.Lint(billing.Query{Location: time.UTC, Search: "CLIENT:"}, items,
+		billing.LintOptions{MaxEventLength: 10 * time.Hour, DailyCap: 12})
+
+	want := []struct {
+		check   billing.Check
+		message string
+	}{
+		{billing.CheckOverlap, `"CLIENT: Review" overlaps "CLIENT: Design" by 30m0s`},
+		{billing.CheckEmptyDescription, `"CLIENT:   "`},
+		{billing.CheckDuration, `"CLIENT: Backwards" lasts -1h0m0s`},
+		{billing.CheckDailyCap, "14h billed, more than 12h"},
+		{billing.CheckMaxLength, `"CLIENT: Migration" lasts 14h0m0s`},
+		{billing.CheckNearMissPrefix, `"CLIENT Support"`},
+		{billing.CheckNearMissPrefix, `"client: Call"`},
+	}
+
+	if len(findings) != len(want) {
+		t.Fatalf("got %d findings, want %d:\n%v", len(findings), len(want), findings)
+	}
+
+	for i, w := range want {
+		if findings[i].Check != w.check || !strings.Contains(findings[i].Message, w.message) {
+			t.Errorf("finding %d: got %v, want %s containing %q", i, findings[i], w.check, w.message)
+		}
+	}
+}
+
+func TestLint_NearMissNonASCII(t *testing.T) {
+	items := []*calendar.Event{
+		timedEvent("čišćenje ureda", "2024-01-15T09:00:00Z", "2024-01-15T10:00:00Z"),
+		timedEvent("ČIŠĆENJEM ureda", "2024-01-15T11:00:00Z", "2024-01-15T12:00:00Z"),
+		// Kelvin sign folds to k but takes three bytes instead of one
+		timedEvent("\u212Ažića, čišćenje", "2024-01-16T09:00:00Z", "2024-01-16T10:00:00Z"),
+	}
+
+	for _, tc := range []struct {
+		search string
+		want   string
+	}{
+		{"ČIŠĆENJE:", `"čišćenje ureda"`},
+		{"KŽIĆA:", "\"\u212Ažića, čišćenje\""},
+	} {
+		findings := billing.Lint(billing.Query{Location: time.UTC, Search: tc.search}, items, billing.LintOptions{})
+
+		if len(findings) != 1 || findings[0].Check != billing.CheckNearMissPrefix ||
+			!strings.Contains(findings[0].Message, tc.want) {
+			t.Errorf("%s: got %v, want one near miss %s", tc.search, findings, tc.want)
+		}
+	}
+}
+
+func TestLint_Clean(t *testing.T) {
+	items := []*calendar.Event{
+		timedEvent("Design", "2024-01-15T09:00:00Z", "2024-01-15T11:00:00Z"),
+		timedEvent("Review", "2024-01-15T11:00:00Z", "2024-01-15T12:00:00Z"),
+	}
+
+	// Back to back events do not overlap, and without a search prefix there are no near misses
+	if findings := billing.Lint(billing.Query{Location: time.UTC}, items, billing.LintOptions{}); len(findings) != 0 {
+		t.Errorf("got %v, want no findings", findings)
+	}
+}
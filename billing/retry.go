@@ -0,0 +1,125 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Retry configures retries of transient Calendar API failures: rate limiting, server errors and network errors. A
+// zero value disables retries.
+type Retry struct {
+	// Log is called before each retry with the failed attempt number, the delay and the error, may be nil
+	Log         func(attempt int, delay time.Duration, err error)
+	MaxAttempts int           // total attempts including the first one
+	BaseDelay   time.Duration // delay before the first retry, doubled on each further retry
+	MaxDelay    time.Duration // upper bound of an exponential delay, Retry-After is honoured as sent
+}
+
+// DefaultRetry retries a failed Calendar API request up to 4 times, waiting about 1, 2, 4 and 8 seconds.
+var DefaultRetry = Retry{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// Calendar API error reasons of request rate limiting, reported with HTTP 403 and worth retrying.
+var rateLimitReasons = []string{"rateLimitExceeded", "userRateLimitExceeded"}
+
+// do calls fn until it succeeds, fails permanently or runs out of attempts. Waiting between attempts is bounded by
+// ctx: when the next delay would pass the ctx deadline, the last error is returned right away.
+func (r Retry) do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := r.delay(attempt, err)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		if r.Log != nil {
+			r.Log(attempt, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the wait before retrying a failed attempt: the server Retry-After if present, otherwise an
+// exponential delay with equal jitter, so concurrent clients do not retry in lockstep.
+func (r Retry) delay(attempt int, err error) time.Duration {
+	if d, ok := retryAfter(err); ok {
+		return d
+	}
+
+	d := r.BaseDelay << (attempt - 1)
+	if d <= 0 || (r.MaxDelay > 0 && d > r.MaxDelay) {
+		d = r.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether a failed Calendar API request may succeed when repeated.
+func retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return apiErrorCategory(err) == ErrUnavailable
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests, apiErr.Code >= http.StatusInternalServerError:
+		return true
+	case apiErr.Code == http.StatusForbidden:
+		return slices.ContainsFunc(apiErr.Errors, func(item googleapi.ErrorItem) bool {
+			return slices.Contains(rateLimitReasons, item.Reason)
+		})
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay requested by a Retry-After header of a Calendar API error, in seconds or as a date.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(t)), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"google.golang.org/api/calendar/v3"
+)
+
+// fastRetry retries quickly and records retried attempts and delays.
+type fastRetry struct {
+	attempts []int
+	delays   []time.Duration
+}
+
+// retry returns a Retry with millisecond delays logging into r.
+func (r *fastRetry) retry(maxAttempts int) billing.Retry {
+	return billing.Retry{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+		Log: func(attempt int, delay time.Duration, _ error) {
+			r.attempts = append(r.attempts, attempt)
+			r.delays = append(r.delays, delay)
+		},
+	}
+}
+
+// newFlakyService returns a Calendar API client for a fake server failing the first failures event list requests
+// with an HTTP status, a JSON error reason and an optional Retry-After header.
+func newFlakyService(t *testing.T, failures int32, status int, reason, retryAfter string) (*calendar.Service,
+	*atomic.Int32,
+) {
+	t.Helper()
+
+	var requests atomic.Int32
+
+	svc := newService(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if requests.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+
+			w.WriteHeader(status)
+			_, _ = fmt.Fprintf(w, `{"error":{"code":%d,"message":"failed","errors":[{"reason":%q}]}}`, status, reason)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"items":[{"summary":"Work","start":{"dateTime":"2024-01-15T09:00:00Z"},` +
+			`"end":{"dateTime":"2024-01-15T11:00:00Z"}}]}`))
+	}))
+
+	return svc, &requests
+}
+
+func TestFetch_RetryTransient(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reason string
+	}{
+		{"server error", http.StatusServiceUnavailable, "backendError"},
+		{"too many requests", http.StatusTooManyRequests, "rateLimitExceeded"},
+		{"rate limited", http.StatusForbidden, "userRateLimitExceeded"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, requests := newFlakyService(t, 2, tc.status, tc.reason, "")
+
+			var rec fastRetry
+
+			r, err := billing.Fetch(context.Background(), srv, billing.Query{Location: time.UTC, Retry: rec.retry(3)})
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+
+			if got := r.Days["2024-01-15"].Hours; got != 2 {
+				t.Errorf("Hours: got %d, want 2", got)
+			}
+
+			if requests.Load() != 3 || len(rec.attempts) != 2 {
+				t.Errorf("got %d requests and %d logged retries, want 3 and 2", requests.Load(), len(rec.attempts))
+			}
+
+			for _, d := range rec.delays {
+				if d > 10*time.Millisecond {
+					t.Errorf("delay %v exceeds MaxDelay", d)
+				}
+			}
+		})
+	}
+}
+
+func TestFetch_RetryExhausted(t *testing.T) {
+	srv, requests := newFlakyService(t, 10, http.StatusInternalServerError, "backendError", "")
+
+	var rec fastRetry
+
+	_, err := billing.Fetch(context.Background(), srv, billing.Query{Retry: rec.retry(3)})
+	if !errors.Is(err, billing.ErrUnavailable) {
+		t.Fatalf("error: got %v, want ErrUnavailable", err)
+	}
+
+	if requests.Load() != 3 {
+		t.Errorf("requests: got %d, want 3", requests.Load())
+	}
+}
+
+func TestFetch_NoRetryPermanent(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reason string
+	}{
+		{"unauthorized", http.StatusUnauthorized, "authError"},
+		{"daily quota", http.StatusForbidden, "dailyLimitExceeded"},
+		{"bad request", http.StatusBadRequest, "invalid"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, requests := newFlakyService(t, 10, tc.status, tc.reason, "")
+
+			var rec fastRetry
+
+			if _, err := billing.Fetch(context.Background(), srv, billing.Query{Retry: rec.retry(3)}); err == nil {
+				t.Fatal("expected error")
+			}
+
+			if requests.Load() != 1 {
+				t.Errorf("requests: got %d, want 1", requests.Load())
+			}
+		})
+	}
+}
+
+func TestFetch_RetryAfter(t *testing.T) {
+	srv, _ := newFlakyService(t, 1, http.StatusTooManyRequests, "rateLimitExceeded", "1")
+
+	var rec fastRetry
+
+	if _, err := billing.Fetch(context.Background(), srv, billing.Query{Retry: rec.retry(3)}); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	// Retry-After is honoured even above MaxDelay
+	if len(rec.delays) != 1 || rec.delays[0] != time.Second {
+		t.Errorf("delays: got %v, want [1s]", rec.delays)
+	}
+}
+
+func TestFetch_RetryBoundedByContext(t *testing.T) {
+	srv, requests := newFlakyService(t, 10, http.StatusTooManyRequests, "rateLimitExceeded", "3600")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rec fastRetry
+
+	start := time.Now()
+
+	_, err := billing.Fetch(ctx, srv, billing.Query{Retry: rec.retry(3)})
+	if !errors.Is(err, billing.ErrQuotaExceeded) {
+		t.Fatalf("error: got %v, want ErrQuotaExceeded", err)
+	}
+
+	// A Retry-After past the context deadline is not waited for
+	if elapsed := time.Since(start); elapsed > time.Second || requests.Load() != 1 {
+		t.Errorf("got %d requests in %v, want 1 without waiting", requests.Load(), elapsed)
+	}
+}
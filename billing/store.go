@@ -0,0 +1,430 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	// storePerms are event store file permissions; events may hold private descriptions.
+	storePerms = 0o600
+
+	// storeDirPerms are event store directory permissions.
+	storeDirPerms = 0o700
+
+	// storeLockTimeout is the wait for another process holding the event store.
+	storeLockTimeout = 5 * time.Second
+
+	// eventCancelled is the status of a deleted event in incremental sync results.
+	eventCancelled = "cancelled"
+)
+
+// Event store bucket and key names.
+var (
+	calendarsBucket = []byte("calendars")
+	eventsBucket    = []byte("events")
+	metaKey         = []byte("meta")
+)
+
+var (
+	ErrStoreOpen  = errors.New("unable to open event store")
+	ErrStore      = errors.New("event store failure")
+	ErrNotSynced  = errors.New("calendar not synced yet")
+	ErrStoreEvent = errors.New("invalid stored event")
+)
+
+// Store keeps synced calendar events in a local bbolt database. After the first full sync, only changes are
+// fetched through Calendar API sync tokens, and reports are built from the local copy. Calendars are kept apart
+// by a scope, such as an account name, so the same calendar name of several accounts does not collide.
+type Store struct {
+	db *bolt.DB
+}
+
+// SyncResult describes a completed calendar sync.
+type SyncResult struct {
+	SyncedAt time.Time
+	Updated  int  // added or changed events
+	Deleted  int  // deleted events
+	Full     bool // all events were listed, either on the first sync or after an expired sync token
+}
+
+// storeMeta is the sync state of a stored calendar.
+type storeMeta struct {
+	SyncedAt   time.Time `json:"synced_at"`
+	CalendarID string    `json:"calendar_id"`
+	SyncToken  string    `json:"sync_token"`
+}
+
+// OpenStore opens or creates an event store at path, waiting a few seconds for another process to release it.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), storeDirPerms); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStoreOpen, err)
+	}
+
+	db, err := bolt.Open(path, storePerms, &bolt.Options{Timeout: storeLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrStoreOpen, path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the event store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Sync brings the stored copy of the query calendar up to date. It lists only changes since the previous sync, and
+// all events on the first sync, when Google expired the sync token (HTTP 410 Gone) or when the calendar name refers
+// to another calendar than on the previous sync, such as after a rename.
+func (s *Store) Sync(ctx context.Context, srv *calendar.Service, scope string, q Query) (SyncResult, error) {
+	key := storeKey(scope, q.Calendar)
+
+	meta, err := s.meta(key)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	// Calendars are stored by name, so the name is resolved on every sync to follow renamed calendars
+	calID, err := CalendarID(ctx, srv, q)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	if meta.CalendarID != calID {
+		if meta.CalendarID != "" {
+			q.logger().DebugContext(ctx, "Calendar name refers to another calendar, listing all events",
+				"calendar", q.Calendar, "previous", meta.CalendarID, "id", calID)
+		}
+
+		meta = storeMeta{CalendarID: calID}
+	}
+
+	if meta.SyncToken != "" {
+		items, token, err := listChanges(ctx, srv, q, meta.CalendarID, meta.SyncToken)
+		if err == nil {
+			return s.apply(key, meta, items, token, false)
+		}
+
+		if !isStatus(err, http.StatusGone) {
+			return SyncResult{}, err
+		}
+
+		q.logger().DebugContext(ctx, "Sync token expired, listing all events", "calendar", meta.CalendarID)
+	}
+
+	items, token, err := listChanges(ctx, srv, q, meta.CalendarID, "")
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	return s.apply(key, meta, items, token, true)
+}
+
+// Synced reports whether a calendar was synced before, so its next sync only lists changes.
+func (s *Store) Synced(scope, calName string) (bool, error) {
+	meta, err := s.meta(storeKey(scope, calName))
+
+	return meta.SyncToken != "", err
+}
+
+// Reset discards stored events and sync state of a calendar, so the next sync lists all events again.
+func (s *Store) Reset(scope, calName string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(calendarsBucket)
+		if root == nil || root.Bucket(storeKey(scope, calName)) == nil {
+			return nil
+		}
+
+		return root.DeleteBucket(storeKey(scope, calName))
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	return nil
+}
+
+// Report bills stored events of the query calendar overlapping the query period, in start time order like an
+// event listing, without any network access. A calendar that was never synced is reported as ErrNotSynced.
+func (s *Store) Report(scope string, q Query) (*Report, error) {
+	items, meta, err := s.events(scope, q)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReport(q)
+	r.SyncedAt = meta.SyncedAt
+	r.addItems(items)
+
+	return r, nil
+}
+
+// Events returns stored events of the query calendar overlapping the query period, in start time order like
+// ListEvents. A calendar that was never synced is reported as ErrNotSynced.
+func (s *Store) Events(scope string, q Query) ([]*calendar.Event, error) {
+	items, _, err := s.events(scope, q)
+
+	return items, err
+}
+
+// events returns stored events of the query calendar overlapping the query period in start time order, and the
+// calendar sync state.
+func (s *Store) events(scope string, q Query) ([]*calendar.Event, storeMeta, error) {
+	key := storeKey(scope, q.Calendar)
+
+	var (
+		items []*calendar.Event
+		meta  storeMeta
+	)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := calendarBucket(tx, key)
+		if b == nil || b.Get(metaKey) == nil {
+			return fmt.Errorf("%w: %q", ErrNotSynced, cmp.Or(q.Calendar, PrimaryCalendar))
+		}
+
+		if err := json.Unmarshal(b.Get(metaKey), &meta); err != nil {
+			return err
+		}
+
+		events := b.Bucket(eventsBucket)
+		if events == nil {
+			return nil
+		}
+
+		return events.ForEach(func(k, v []byte) error {
+			item := new(calendar.Event)
+			if err := json.Unmarshal(v, item); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrStoreEvent, k, err)
+			}
+
+			if q.overlaps(item) {
+				items = append(items, item)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotSynced) || errors.Is(err, ErrStoreEvent) {
+			return nil, storeMeta{}, err
+		}
+
+		return nil, storeMeta{}, fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	loc := q.location()
+
+	slices.SortFunc(items, func(a, b *calendar.Event) int {
+		ta, _ := eventTime(a.Start, loc)
+		tb, _ := eventTime(b.Start, loc)
+
+		return cmp.Or(ta.Compare(tb), cmp.Compare(a.Id, b.Id))
+	})
+
+	return items, meta, nil
+}
+
+// listChanges lists all events of a calendar, or changes since a sync token, returning the next sync token.
+// Incremental results include deleted events with a cancelled status.
+func listChanges(ctx context.Context, srv *calendar.Service, q Query, calID, syncToken string) ([]*calendar.Event,
+	string, error,
+) {
+	var (
+		items     []*calendar.Event
+		nextToken string
+	)
+
+	err := listEvents(ctx, srv, q, calID, func(call *calendar.EventsListCall) *calendar.EventsListCall {
+		// Sync tokens cannot be combined with a time range or ordering, so the whole calendar is listed
+		if syncToken != "" {
+			return call.SyncToken(syncToken)
+		}
+
+		return call.ShowDeleted(false)
+	}, func(events *calendar.Events) {
+		items = append(items, events.Items...)
+		nextToken = events.NextSyncToken
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, nextToken, nil
+}
+
+// apply stores listed events and the next sync token of a calendar in a single transaction, so stored events always
+// match the sync token. A full listing replaces all stored events.
+func (s *Store) apply(key []byte, meta storeMeta, items []*calendar.Event, token string, full bool) (SyncResult,
+	error,
+) {
+	res := SyncResult{SyncedAt: time.Now(), Full: full}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(calendarsBucket)
+		if err != nil {
+			return err
+		}
+
+		b, err := root.CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+
+		if full && b.Bucket(eventsBucket) != nil {
+			if err := b.DeleteBucket(eventsBucket); err != nil {
+				return err
+			}
+		}
+
+		events, err := b.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if item.Status == eventCancelled {
+				if err := events.Delete([]byte(item.Id)); err != nil {
+					return err
+				}
+
+				res.Deleted++
+
+				continue
+			}
+
+			v, err := storedEvent(item)
+			if err != nil {
+				return err
+			}
+
+			if err := events.Put([]byte(item.Id), v); err != nil {
+				return err
+			}
+
+			res.Updated++
+		}
+
+		meta.SyncToken = token
+		meta.SyncedAt = res.SyncedAt
+
+		v, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(metaKey, v)
+	})
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	return res, nil
+}
+
+// meta returns the sync state of a stored calendar, empty if it was never synced.
+func (s *Store) meta(key []byte) (storeMeta, error) {
+	var meta storeMeta
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := calendarBucket(tx, key)
+		if b == nil {
+			return nil
+		}
+
+		if v := b.Get(metaKey); v != nil {
+			return json.Unmarshal(v, &meta)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return storeMeta{}, fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	return meta, nil
+}
+
+// calendarBucket returns the bucket of a stored calendar, nil if it was never synced.
+func calendarBucket(tx *bolt.Tx, key []byte) *bolt.Bucket {
+	root := tx.Bucket(calendarsBucket)
+	if root == nil {
+		return nil
+	}
+
+	return root.Bucket(key)
+}
+
+// storeKey returns the bucket key of a calendar name within a scope.
+func storeKey(scope, calName string) []byte {
+	return []byte(scope + "\x00" + calName)
+}
+
+// storedEvent encodes an event with only the fields in eventItemFields, so stored events match live listings.
+func storedEvent(item *calendar.Event) ([]byte, error) {
+	v, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(v, &fields); err != nil {
+		return nil, err
+	}
+
+	maps.DeleteFunc(fields, func(k string, _ json.RawMessage) bool { return !slices.Contains(eventItemFields, k) })
+
+	return json.Marshal(fields)
+}
+
+// overlaps reports whether an event overlaps the query period, like a time range of an event listing. A zero query
+// start or end leaves the period open.
+func (q Query) overlaps(item *calendar.Event) bool {
+	if item.Start == nil || item.End == nil {
+		return false
+	}
+
+	loc := q.location()
+
+	start, err := eventTime(item.Start, loc)
+	if err != nil {
+		return false
+	}
+
+	end, err := eventTime(item.End, loc)
+	if err != nil {
+		return false
+	}
+
+	return (q.End.IsZero() || start.Before(q.End)) && (q.Start.IsZero() || end.After(q.Start))
+}
+
+// eventTime parses a timed event date-time, or the date of an all-day event in loc.
+func eventTime(dt *calendar.EventDateTime, loc *time.Location) (time.Time, error) {
+	if dt == nil {
+		return time.Time{}, ErrEventTime
+	}
+
+	if dt.DateTime != "" {
+		return time.Parse(time.RFC3339, dt.DateTime)
+	}
+
+	return time.ParseInLocation(DateLayout, dt.Date, loc)
+}
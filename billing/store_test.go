@@ -0,0 +1,319 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"google.golang.org/api/calendar/v3"
+)
+
+// syncServer fakes an event listing with sync tokens: a full listing returns token t1, changes since t1 return
+// token t2 and any other token has expired, as has t2 once expire is set.
+type syncServer struct {
+	fullSyncs atomic.Int32
+	deltas    atomic.Int32
+	expire    atomic.Bool
+}
+
+// ServeHTTP implements http.Handler.
+func (s *syncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+
+	switch token := query.Get("syncToken"); {
+	case token == "" && query.Get("timeMin") != "":
+		w.WriteHeader(http.StatusBadRequest)
+	case token == "" && query.Get("pageToken") == "":
+		s.fullSyncs.Add(1)
+		_, _ = w.Write([]byte(`{"nextPageToken":"page-2","items":[` +
+			`{"id":"a","summary":"Second","start":{"dateTime":"2024-01-15T13:00:00Z"},"end":{"dateTime":"2024-01-15T14:00:00Z"},` +
+			`"transparency":"transparent","attendees":[{"email":"client@example.com","responseStatus":"accepted"}],` +
+			`"location":"Office"},` +
+			`{"id":"b","summary":"First","start":{"dateTime":"2024-01-15T09:00:00Z"},"end":{"dateTime":"2024-01-15T11:00:00Z"}}` +
+			`]}`))
+	case token == "":
+		_, _ = w.Write([]byte(`{"nextSyncToken":"t1","items":[` +
+			`{"id":"c","summary":"Last year","start":{"dateTime":"2023-01-16T09:00:00Z"},"end":{"dateTime":"2023-01-16T10:00:00Z"}},` +
+			`{"id":"d","summary":"Offsite","start":{"date":"2024-01-17"},"end":{"date":"2024-01-18"}}` +
+			`]}`))
+	case token == "t1":
+		s.deltas.Add(1)
+		_, _ = w.Write([]byte(`{"nextSyncToken":"t2","items":[` +
+			`{"id":"a","status":"cancelled"},` +
+			`{"id":"b","summary":"First, moved","start":{"dateTime":"2024-01-16T09:00:00Z"},"end":{"dateTime":"2024-01-16T12:00:00Z"}}` +
+			`]}`))
+	case token == "t2" && !s.expire.Load():
+		s.deltas.Add(1)
+		_, _ = w.Write([]byte(`{"nextSyncToken":"t2","items":[]}`))
+	default:
+		w.WriteHeader(http.StatusGone)
+		_, _ = w.Write([]byte(`{"error":{"code":410,"message":"Sync token is no longer valid","errors":[{"reason":"fullSyncRequired"}]}}`))
+	}
+}
+
+// newSyncService returns a Calendar API client for a fake sync server.
+func newSyncService(t *testing.T) (*calendar.Service, *syncServer) {
+	t.Helper()
+
+	handler := &syncServer{}
+
+	return newService(t, handler), handler
+}
+
+// openStore opens an event store in a test directory.
+func openStore(t *testing.T) *billing.Store {
+	t.Helper()
+
+	store, err := billing.OpenStore(filepath.Join(t.TempDir(), "events", "events.db"))
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+// january is a query of January 2024 in UTC.
+var january = billing.Query{
+	Start:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	End:      time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	Location: time.UTC,
+}
+
+func TestStore_FullSyncAndReport(t *testing.T) {
+	srv, handler := newSyncService(t)
+	store := openStore(t)
+
+	res, err := store.Sync(context.Background(), srv, "work", january)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if !res.Full || res.Updated != 4 || handler.fullSyncs.Load() != 1 {
+		t.Errorf("SyncResult: got %+v after %d full syncs, want a full sync of 4 events", res, handler.fullSyncs.Load())
+	}
+
+	r, err := store.Report("work", january)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	if !r.SyncedAt.Equal(res.SyncedAt) {
+		t.Errorf("SyncedAt: got %v, want %v", r.SyncedAt, res.SyncedAt)
+	}
+
+	// Events are billed in start time order and outside of the period are left out
+	if got := r.Days["2024-01-15"]; got.Hours != 3 || got.Description() != "First, Second" {
+		t.Errorf("2024-01-15: got %dh %q, want 3h %q", got.Hours, got.Description(), "First, Second")
+	}
+
+	if len(r.Days) != 1 {
+		t.Errorf("Days: got %v, want only 2024-01-15", r.Dates())
+	}
+
+	// All-day events in the period are skipped like in a direct listing
+	if len(r.Skipped) != 1 || !errors.Is(r.Skipped[0], billing.ErrEventTime) {
+		t.Errorf("Skipped: got %v, want one ErrEventTime", r.Skipped)
+	}
+}
+
+func TestStore_KeepsListedFields(t *testing.T) {
+	srv, _ := newSyncService(t)
+	store := openStore(t)
+
+	if _, err := store.Sync(context.Background(), srv, "work", january); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	items, err := store.Events("work", january)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+
+	i := slices.IndexFunc(items, func(item *calendar.Event) bool { return item.Id == "a" })
+	if i < 0 {
+		t.Fatalf("Events: event a missing from %d events", len(items))
+	}
+
+	// Stored events carry every listed field, and nothing else
+	got := items[i]
+	if got.Transparency != "transparent" || len(got.Attendees) != 1 || got.Attendees[0].Email != "client@example.com" {
+		t.Errorf("event a: got transparency %q and attendees %v, want both stored", got.Transparency, got.Attendees)
+	}
+
+	if got.Location != "" {
+		t.Errorf("event a: got location %q, want it dropped", got.Location)
+	}
+}
+
+func TestStore_IncrementalSync(t *testing.T) {
+	srv, handler := newSyncService(t)
+	store := openStore(t)
+
+	for range 2 {
+		if _, err := store.Sync(context.Background(), srv, "work", january); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+
+	if handler.fullSyncs.Load() != 1 || handler.deltas.Load() != 1 {
+		t.Errorf("got %d full and %d incremental syncs, want 1 and 1", handler.fullSyncs.Load(), handler.deltas.Load())
+	}
+
+	r, err := store.Report("work", january)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	// Event a was deleted and event b moved to the next day
+	if _, ok := r.Days["2024-01-15"]; ok {
+		t.Errorf("2024-01-15 must be gone after incremental sync, got %q", r.Days["2024-01-15"].Description())
+	}
+
+	if got := r.Days["2024-01-16"]; got.Hours != 3 || got.Description() != "First, moved" {
+		t.Errorf("2024-01-16: got %dh %q, want 3h %q", got.Hours, got.Description(), "First, moved")
+	}
+}
+
+func TestStore_ScopeAndReset(t *testing.T) {
+	srv, handler := newSyncService(t)
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	store, err := billing.OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+
+	if _, err := store.Sync(context.Background(), srv, "work", january); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	_ = store.Close()
+
+	// Sync tokens are scoped, another scope of the same calendar starts from scratch
+	store, err = billing.OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+
+	defer func() { _ = store.Close() }()
+
+	if _, err := store.Report("personal", january); !errors.Is(err, billing.ErrNotSynced) {
+		t.Fatalf("Report of another scope: got %v, want ErrNotSynced", err)
+	}
+
+	if err := store.Reset("work", ""); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if _, err := store.Report("work", january); !errors.Is(err, billing.ErrNotSynced) {
+		t.Fatalf("Report after Reset: got %v, want ErrNotSynced", err)
+	}
+
+	res, err := store.Sync(context.Background(), srv, "work", january)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if !res.Full || handler.fullSyncs.Load() != 2 {
+		t.Errorf("got %+v after %d full syncs, want a second full sync", res, handler.fullSyncs.Load())
+	}
+}
+
+func TestStore_GoneTriggersFullSync(t *testing.T) {
+	srv, handler := newSyncService(t)
+	store := openStore(t)
+
+	for range 2 {
+		if _, err := store.Sync(context.Background(), srv, "work", january); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+
+	handler.expire.Store(true)
+
+	res, err := store.Sync(context.Background(), srv, "work", january)
+	if err != nil {
+		t.Fatalf("Sync with expired token: %v", err)
+	}
+
+	if !res.Full || handler.fullSyncs.Load() != 2 {
+		t.Errorf("got %+v after %d full syncs, want a full resync", res, handler.fullSyncs.Load())
+	}
+
+	r, err := store.Report("work", january)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	// A full resync replaces incrementally synced events
+	if got := r.Days["2024-01-15"]; got.Description() != "First, Second" {
+		t.Errorf("2024-01-15: got %q, want %q", got.Description(), "First, Second")
+	}
+
+	if _, ok := r.Days["2024-01-16"]; ok {
+		t.Error("2024-01-16 must be gone after a full resync")
+	}
+}
+
+func TestStore_RenamedCalendar(t *testing.T) {
+	var workID atomic.Value
+
+	workID.Store("old-id")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/me/calendarList", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"items":[{"id":%q,"summary":"Work"}]}`, workID.Load())
+	})
+	mux.HandleFunc("/calendars/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"nextSyncToken":"t-%[1]s","items":[{"id":"e-%[1]s","summary":"Event of %[1]s",`+
+			`"start":{"dateTime":"2024-01-15T09:00:00Z"},"end":{"dateTime":"2024-01-15T10:00:00Z"}}]}`, r.PathValue("id"))
+	})
+
+	srv := newService(t, mux)
+	store := openStore(t)
+
+	q := january
+	q.Calendar = "Work"
+
+	if _, err := store.Sync(context.Background(), srv, "work", q); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Another calendar takes the name, for example after the old one was renamed
+	workID.Store("new-id")
+
+	res, err := store.Sync(context.Background(), srv, "work", q)
+	if err != nil {
+		t.Fatalf("Sync after rename: %v", err)
+	}
+
+	if !res.Full {
+		t.Errorf("SyncResult: got %+v, want a full sync of the new calendar", res)
+	}
+
+	r, err := store.Report("work", q)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	if got := r.Days["2024-01-15"].Description(); got != "Event of new-id" {
+		t.Errorf("2024-01-15: got %q, want only events of the new calendar", got)
+	}
+}
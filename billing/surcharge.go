@@ -0,0 +1,210 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DefaultWorkHours is a default daily working hours range; work outside of it is reported as after-hours work.
+const DefaultWorkHours = "09:00-17:00"
+
+// AfterHoursName is a surcharge line name of after-hours work.
+const AfterHoursName = "After hours"
+
+// workHoursLayout is a Time format parse layout of "HH:MM".
+const workHoursLayout = "15:04"
+
+var ErrWorkHours = errors.New("working hours must be in HH:MM-HH:MM format with start before end")
+
+// WorkHours is a daily working hours range as offsets from midnight. A zero value disables after-hours detection.
+type WorkHours struct {
+	Start, End time.Duration
+}
+
+// DayCategory is a surcharge category of a whole work day.
+type DayCategory int
+
+const (
+	CategoryRegular DayCategory = iota
+	CategorySaturday
+	CategorySunday
+	CategoryHoliday
+)
+
+// String returns a human-readable day category name.
+func (c DayCategory) String() string {
+	switch c {
+	case CategorySaturday:
+		return "Saturday"
+	case CategorySunday:
+		return "Sunday"
+	case CategoryHoliday:
+		return "Holiday"
+	default:
+		return "Regular"
+	}
+}
+
+// Multipliers are billed amount multipliers per surcharge category; a multiplier of 1 adds no surcharge.
+type Multipliers struct {
+	Saturday   float64
+	Sunday     float64
+	Holiday    float64
+	AfterHours float64
+}
+
+// NoSurcharge bills every hour at the regular rate.
+var NoSurcharge = Multipliers{Saturday: 1, Sunday: 1, Holiday: 1, AfterHours: 1}
+
+// SurchargeLine holds billed hours and a multiplier for a single surcharge category.
+type SurchargeLine struct {
+	Name       string
+	Hours      int
+	Multiplier float64
+}
+
+// Amount returns the surcharge on top of the regular rate, which is already part of the base amount.
+func (l SurchargeLine) Amount(rate float64) float64 {
+	return float64(l.Hours) * rate * (l.Multiplier - 1)
+}
+
+// ParseWorkHours parses a "HH:MM-HH:MM" daily working hours range.
+func ParseWorkHours(s string) (WorkHours, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return WorkHours{}, fmt.Errorf("%w: %q", ErrWorkHours, s)
+	}
+
+	start, err := time.Parse(workHoursLayout, strings.TrimSpace(startStr))
+	if err != nil {
+		return WorkHours{}, fmt.Errorf("%w: %q", ErrWorkHours, s)
+	}
+
+	end, err := time.Parse(workHoursLayout, strings.TrimSpace(endStr))
+	if err != nil {
+		return WorkHours{}, fmt.Errorf("%w: %q", ErrWorkHours, s)
+	}
+
+	wh := WorkHours{
+		Start: time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		End:   time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+	}
+
+	if wh.Start >= wh.End {
+		return WorkHours{}, fmt.Errorf("%w: %q", ErrWorkHours, s)
+	}
+
+	return wh, nil
+}
+
+// AfterHours returns billed hours of an event outside working hours on its start day, capped at billed hours.
+func (wh WorkHours) AfterHours(startTime, endTime time.Time, hours int) int {
+	if wh.Start == wh.End {
+		return 0
+	}
+
+	workStart := wallClock(startTime, wh.Start)
+	workEnd := wallClock(startTime, wh.End)
+
+	// Overlap of event and working hours
+	overlap := max(0, minTime(endTime, workEnd).Sub(maxTime(startTime, workStart)))
+	outside := endTime.Sub(startTime) - overlap
+
+	return min(hours, int(math.Ceil(outside.Hours())))
+}
+
+// wallClock returns the time of day at an offset from midnight on the day of t, read as a wall clock like working
+// hours, so that a DST change on that day does not shift it.
+func wallClock(t time.Time, offset time.Duration) time.Time {
+	h, m := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+
+	return time.Date(t.Year(), t.Month(), t.Day(), h, m, 0, 0, t.Location())
+}
+
+// Classify returns a surcharge category of a "YYYY-MM-DD" day; public holidays take precedence over weekends.
+func Classify(k string, holidays map[string]Holiday) DayCategory {
+	if _, ok := holidays[k]; ok {
+		return CategoryHoliday
+	}
+
+	day, err := time.Parse(DateLayout, k)
+	if err != nil {
+		return CategoryRegular
+	}
+
+	switch day.Weekday() {
+	case time.Saturday:
+		return CategorySaturday
+	case time.Sunday:
+		return CategorySunday
+	default:
+		return CategoryRegular
+	}
+}
+
+// Category returns a surcharge category of a "YYYY-MM-DD" day of the report.
+func (r *Report) Category(k string) DayCategory {
+	return Classify(k, r.Holidays)
+}
+
+// Surcharges sums billed hours per surcharge category. A whole day falls into a single day category, while
+// after-hours work is only counted on regular working days so no hour is surcharged twice.
+func (r *Report) Surcharges(m Multipliers) []SurchargeLine {
+	lines := []SurchargeLine{
+		{Name: CategorySaturday.String(), Multiplier: m.Saturday},
+		{Name: CategorySunday.String(), Multiplier: m.Sunday},
+		{Name: CategoryHoliday.String(), Multiplier: m.Holiday},
+		{Name: AfterHoursName, Multiplier: m.AfterHours},
+	}
+
+	for k, v := range r.Days {
+		switch r.Category(k) {
+		case CategorySaturday:
+			lines[0].Hours += v.Hours
+		case CategorySunday:
+			lines[1].Hours += v.Hours
+		case CategoryHoliday:
+			lines[2].Hours += v.Hours
+		default:
+			lines[3].Hours += v.AfterHours
+		}
+	}
+
+	return lines
+}
+
+// BilledAmount returns the amount of all billed hours at rate including surcharges.
+func (r *Report) BilledAmount(rate float64, m Multipliers) float64 {
+	total := float64(r.TotalHours()) * rate
+
+	for _, l := range r.Surcharges(m) {
+		total += l.Amount(rate)
+	}
+
+	return total
+}
+
+// minTime returns the earlier of two times.
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}
+
+// maxTime returns the later of two times.
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package billing_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Zagreb without a system time zone database
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+func TestParseWorkHours(t *testing.T) {
+	wh, err := billing.ParseWorkHours("08:30-16:00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if wh.Start != 8*time.Hour+30*time.Minute || wh.End != 16*time.Hour {
+		t.Errorf("got %v-%v, want 8h30m-16h", wh.Start, wh.End)
+	}
+
+	for _, s := range []string{"08:00", "8-16", "17:00-09:00", "09:00-09:00"} {
+		if _, err := billing.ParseWorkHours(s); !errors.Is(err, billing.ErrWorkHours) {
+			t.Errorf("%q: expected ErrWorkHours, got %v", s, err)
+		}
+	}
+}
+
+func TestWorkHours_AfterHours(t *testing.T) {
+	wh := billing.WorkHours{Start: 9 * time.Hour, End: 17 * time.Hour}
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		hours      int
+		want       int
+	}{
+		{"inside working hours", 9 * time.Hour, 17 * time.Hour, 8, 0},
+		{"early start", 7 * time.Hour, 10 * time.Hour, 3, 2},
+		{"late finish", 16 * time.Hour, 19*time.Hour + 30*time.Minute, 4, 3},
+		{"night work", 20 * time.Hour, 23 * time.Hour, 3, 3},
+		{"capped at billed hours", 16*time.Hour + 30*time.Minute, 17*time.Hour + 15*time.Minute, 1, 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := wh.AfterHours(day.Add(tc.start), day.Add(tc.end), tc.hours); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	if got := (billing.WorkHours{}).AfterHours(day.Add(20*time.Hour), day.Add(23*time.Hour), 3); got != 0 {
+		t.Errorf("zero WorkHours must disable after-hours detection, got %d", got)
+	}
+}
+
+func TestWorkHours_AfterHoursDST(t *testing.T) {
+	zagreb, err := time.LoadLocation("Europe/Zagreb")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	wh := billing.WorkHours{Start: 9 * time.Hour, End: 17 * time.Hour}
+
+	// Clocks move on these days, working hours must still follow the wall clock
+	for _, day := range []time.Time{
+		time.Date(2024, 3, 31, 0, 0, 0, 0, zagreb),
+		time.Date(2024, 10, 27, 0, 0, 0, 0, zagreb),
+	} {
+		at := func(h int) time.Time { return time.Date(day.Year(), day.Month(), day.Day(), h, 0, 0, 0, zagreb) }
+
+		if got := wh.AfterHours(at(9), at(17), 8); got != 0 {
+			t.Errorf("%v 09:00-17:00: got %dh after hours, want 0", day.Format(billing.DateLayout), got)
+		}
+
+		if got := wh.AfterHours(at(17), at(18), 1); got != 1 {
+			t.Errorf("%v 17:00-18:00: got %dh after hours, want 1", day.Format(billing.DateLayout), got)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	holidays := map[string]billing.Holiday{
+		"2024-12-25": {Description: "Christmas Day"},
+		"2024-06-22": {Description: "Anti-Fascist Struggle Day"}, // Saturday
+	}
+
+	tests := map[string]billing.DayCategory{
+		"2024-01-15": billing.CategoryRegular,
+		"2024-01-13": billing.CategorySaturday,
+		"2024-01-14": billing.CategorySunday,
+		"2024-12-25": billing.CategoryHoliday,
+		"2024-06-22": billing.CategoryHoliday, // holiday takes precedence over weekend
+	}
+
+	for k, want := range tests {
+		if got := billing.Classify(k, holidays); got != want {
+			t.Errorf("%s: got %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestReport_BilledAmount(t *testing.T) {
+	r := billing.NewReport(billing.Query{})
+	r.Days["2024-01-13"] = billing.NewDay("Saturday work", 4, 0)
+	r.Days["2024-01-15"] = billing.NewDay("Late work", 8, 2)
+	r.Days["2024-01-16"] = billing.NewDay("Normal work", 8, 0)
+	r.Days["2024-01-06"] = billing.NewDay("Holiday work", 2, 1) // after-hours on a holiday is not surcharged twice
+	r.Holidays["2024-01-06"] = billing.Holiday{Description: "Epiphany"}
+
+	m := billing.Multipliers{Saturday: 1.5, Sunday: 2, Holiday: 2, AfterHours: 1.25}
+
+	want := map[string]int{"Saturday": 4, "Sunday": 0, "Holiday": 2, billing.AfterHoursName: 2}
+	for _, l := range r.Surcharges(m) {
+		if l.Hours != want[l.Name] {
+			t.Errorf("%s surcharge hours: got %d, want %d", l.Name, l.Hours, want[l.Name])
+		}
+	}
+
+	// 22h*100 base + 4h*100*0.5 + 2h*100*1 + 2h*100*0.25 = 2200 + 200 + 200 + 50
+	if got := r.BilledAmount(100, m); got != 2650 {
+		t.Errorf("BilledAmount: got %.2f, want 2650.00", got)
+	}
+
+	if got := r.BilledAmount(100, billing.NoSurcharge); got != 2200 {
+		t.Errorf("BilledAmount without surcharges: got %.2f, want 2200.00", got)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/renameio/v2/maybe"
+)
+
+const (
+	// AppName is a cache subdirectory name inside the user cache directory.
+	AppName = "IM-billing-v2"
+
+	// DefaultPerms are default cache entry file permissions.
+	DefaultPerms = 0o600
+
+	// DefaultDirPerms are default cache directory permissions.
+	DefaultDirPerms = 0o700
+
+	// maxBodySize is a maximum cached response body size.
+	maxBodySize = 20 << 20
+)
+
+var (
+	ErrNilBody   = errors.New("client body is nil")
+	ErrNotCached = errors.New("no cached response available offline")
+)
+
+// Entry is a cached HTTP response body with its validators.
+type Entry struct {
+	Fetched      time.Time `json:"fetched"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Body         []byte    `json:"body"`
+}
+
+// Cache is an on-disk HTTP response cache with a TTL and ETag / If-Modified-Since revalidation.
+type Cache struct {
+	Dir     string
+	TTL     time.Duration
+	Offline bool // serve entries of any age and never fetch, a missing entry is ErrNotCached
+}
+
+// DefaultDir returns a default cache directory, $XDG_CACHE_HOME/IM-billing-v2 on Unix systems.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, AppName), nil
+}
+
+// New creates a cache in a directory with a TTL, after which entries are revalidated.
+func New(dir string, ttl time.Duration) *Cache {
+	return &Cache{Dir: dir, TTL: ttl}
+}
+
+// path returns a cache entry file path for a key.
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load reads a cache entry for a key.
+func (c *Cache) Load(key string) (*Entry, error) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{}
+
+	if err = json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Store atomically writes a cache entry for a key.
+func (c *Cache) Store(key string, e *Entry) error {
+	if err := os.MkdirAll(c.Dir, DefaultDirPerms); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return maybe.WriteFile(c.path(key), b, DefaultPerms)
+}
+
+// Fresh reports whether a cache entry is younger than TTL.
+func (c *Cache) Fresh(e *Entry) bool {
+	return time.Since(e.Fetched) < c.TTL
+}
+
+// Get returns a response body for a URL. Fresh entries are returned without a request, stale entries are revalidated
+// with ETag / If-Modified-Since, and if the remote fetch fails a stale entry is returned instead of an error. Offline,
+// only cached entries are returned.
+func (c *Cache) Get(ctx context.Context, httpClient *http.Client, url string) ([]byte, error) {
+	// Missing or corrupted entries are treated as a cache miss
+	e, _ := c.Load(url)
+	if e != nil && (c.Offline || c.Fresh(e)) {
+		return e.Body, nil
+	}
+
+	if c.Offline {
+		return nil, fmt.Errorf("%w: %s", ErrNotCached, url)
+	}
+
+	body, err := c.fetch(ctx, httpClient, url, e)
+	if err != nil {
+		if e != nil {
+			return e.Body, nil
+		}
+
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// fetch does a conditional HTTP GET request and updates the cache entry.
+func (c *Cache) fetch(ctx context.Context, httpClient *http.Client, url string, e *Entry) (body []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revalidate existing entry
+	if e != nil {
+		if e.ETag != "" {
+			req.Header.Set("If-None-Match", e.ETag)
+		}
+
+		if e.LastModified != "" {
+			req.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+
+	// Do the actual HTTP/HTTPS request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			return nil, err
+		}
+	}
+
+	if resp == nil || resp.Body == nil {
+		return nil, fmt.Errorf("%w", ErrNilBody)
+	}
+
+	// Defer body close() with error propagation
+	defer func() {
+		cerr := resp.Body.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && e != nil:
+		e.Fetched = time.Now()
+		body = e.Body
+	case resp.StatusCode == http.StatusOK:
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return nil, err
+		}
+
+		e = &Entry{
+			Fetched:      time.Now(),
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Body:         body,
+		}
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(b))
+	}
+
+	// A failed cache write must not fail an otherwise successful fetch
+	_ = c.Store(url, e)
+
+	return body, nil
+}
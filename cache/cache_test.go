@@ -0,0 +1,187 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/cache"
+)
+
+const etag = `"v1"`
+
+// newCacheTestServer serves a fixed body with an ETag, answering matching revalidations with 304.
+func newCacheTestServer(t *testing.T, status *atomic.Int32, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+
+		if s := int(status.Load()); s != http.StatusOK {
+			w.WriteHeader(s)
+
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte("payload"))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestGet_FreshEntryServedWithoutRequest(t *testing.T) {
+	var status, hits atomic.Int32
+
+	status.Store(http.StatusOK)
+
+	srv := newCacheTestServer(t, &status, &hits)
+	c := cache.New(t.TempDir(), time.Hour)
+
+	for range 2 {
+		body, err := c.Get(context.Background(), srv.Client(), srv.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+
+		if string(body) != "payload" {
+			t.Errorf("body: got %q, want %q", body, "payload")
+		}
+	}
+
+	if hits.Load() != 1 {
+		t.Errorf("expected 1 request, got %d", hits.Load())
+	}
+}
+
+func TestGet_StaleEntryRevalidated(t *testing.T) {
+	var status, hits atomic.Int32
+
+	status.Store(http.StatusOK)
+
+	srv := newCacheTestServer(t, &status, &hits)
+	c := cache.New(t.TempDir(), 0)
+
+	if _, err := c.Get(context.Background(), srv.Client(), srv.URL); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	before, err := c.Load(srv.URL)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if before.ETag != etag {
+		t.Errorf("ETag: got %q, want %q", before.ETag, etag)
+	}
+
+	body, err := c.Get(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if string(body) != "payload" {
+		t.Errorf("body after 304: got %q, want %q", body, "payload")
+	}
+
+	after, err := c.Load(srv.URL)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !after.Fetched.After(before.Fetched) {
+		t.Error("expected revalidated entry fetch time to be refreshed")
+	}
+
+	if hits.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", hits.Load())
+	}
+}
+
+func TestGet_StaleFallbackOnError(t *testing.T) {
+	var status, hits atomic.Int32
+
+	status.Store(http.StatusOK)
+
+	srv := newCacheTestServer(t, &status, &hits)
+	c := cache.New(t.TempDir(), 0)
+
+	if _, err := c.Get(context.Background(), srv.Client(), srv.URL); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+
+	body, err := c.Get(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("expected stale fallback, got error: %v", err)
+	}
+
+	if string(body) != "payload" {
+		t.Errorf("body: got %q, want stale %q", body, "payload")
+	}
+}
+
+func TestGet_MissPropagatesError(t *testing.T) {
+	var status, hits atomic.Int32
+
+	status.Store(http.StatusServiceUnavailable)
+
+	srv := newCacheTestServer(t, &status, &hits)
+	c := cache.New(t.TempDir(), time.Hour)
+
+	if _, err := c.Get(context.Background(), srv.Client(), srv.URL); err == nil {
+		t.Fatal("expected error without a cached entry, got nil")
+	}
+
+	if _, err := c.Load(srv.URL); err == nil {
+		t.Error("failed response must not be cached")
+	}
+}
+
+func TestGet_Offline(t *testing.T) {
+	var status, hits atomic.Int32
+
+	status.Store(http.StatusOK)
+
+	srv := newCacheTestServer(t, &status, &hits)
+	c := cache.New(t.TempDir(), 0)
+
+	if _, err := c.Get(context.Background(), srv.Client(), srv.URL); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	c.Offline = true
+
+	body, err := c.Get(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("offline Get of a stale entry: %v", err)
+	}
+
+	if string(body) != "payload" {
+		t.Errorf("body: got %q, want %q", body, "payload")
+	}
+
+	if _, err := c.Get(context.Background(), srv.Client(), srv.URL+"/missing"); !errors.Is(err, cache.ErrNotCached) {
+		t.Errorf("offline Get of a missing entry: got %v, want ErrNotCached", err)
+	}
+
+	if hits.Load() != 1 {
+		t.Errorf("expected no requests offline, got %d in total", hits.Load())
+	}
+}
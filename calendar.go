@@ -5,229 +5,239 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math"
-	"slices"
+	"log/slog"
+	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/dkorunic/IM-billing-v2/cache"
 	"github.com/dkorunic/IM-billing-v2/geoip"
+	"github.com/dkorunic/IM-billing-v2/holidays"
 	"github.com/dkorunic/IM-billing-v2/ics"
 	"google.golang.org/api/calendar/v3"
 )
 
-// workEvent holds individual calendar event with aggregate hourly total.
-// workDesc is a *strings.Builder so repeated same-day events append in place
-// instead of re-allocating a new string on every concatenation. A pointer is
-// used because workEvent values are copied on map read/write, and a
-// strings.Builder must not be copied after first use.
-type workEvent struct {
-	workDesc   *strings.Builder
-	hoursTotal int
+// eventStoreFile is the local event store file name inside the cache directory.
+const eventStoreFile = "events.db"
+
+// newQuery returns a billing query of a calendar for the report period and event selection flags.
+func newQuery(calName string) billing.Query {
+	return billing.Query{
+		Start:            startDateFinal,
+		End:              endDateFinal,
+		Location:         time.Local,
+		Calendar:         calName,
+		Search:           *searchString,
+		WorkHours:        workHoursFinal,
+		Retry:            apiRetry(),
+		Logger:           slog.Default(),
+		IncludeRecurring: *includeRecurring,
+		Explain:          *explainFlag,
+	}
 }
 
-// holidayEvent holds individual calendar holiday event.
-type holidayEvent struct {
-	holidayDesc string
-}
+// apiRetry returns Calendar API retries, logged at debug level.
+func apiRetry() billing.Retry {
+	retry := billing.DefaultRetry
+	retry.Log = func(attempt int, delay time.Duration, err error) {
+		slog.Debug("Calendar API request failed, retrying", "attempt", attempt, "delay",
+			delay.Round(time.Millisecond), "error", err)
+	}
 
-// dateLayout is a Time format parse layout of "YYYY-MM-DD".
-const dateLayout = "2006-01-02"
+	return retry
+}
 
-// calendarMaxResults is a default maximum number of Google API results.
-const calendarMaxResults = 200
+// getReport fetches and merges billed events of all report sources; events that cannot be billed are logged. With
+// a cache directory, events are synced into a local event store first and billed from there.
+func getReport(ctx context.Context, sources []calendarSource) (*billing.Report, error) {
+	var store *billing.Store
 
-// getCalendarID gets a Google calendar ID out of a symbolic calendar name.
-func getCalendarID(ctx context.Context, srv *calendar.Service, calendarName *string) string {
-	// If the calendar name is not specified, use default (primary) calendar
-	if *calendarName == "" {
-		return "primary"
-	}
+	if cacheDirFinal != "" {
+		s, err := openEventStore()
+		if err != nil {
+			return nil, err
+		}
 
-	nextPageToken := ""
+		defer func() { _ = s.Close() }()
 
-	var availableNames []string
+		store = s
+	}
 
-	// Get calendar listing (paginated) and try to match name
-	for {
-		calendarsCall := srv.CalendarList.List().
-			MaxResults(calendarMaxResults).
-			PageToken(nextPageToken).
-			Context(ctx)
+	report := billing.NewReport(newQuery(*calendarName))
 
-		listCal, err := calendarsCall.Do()
+	for _, src := range sources {
+		r, err := fetchReport(ctx, store, src)
 		if err != nil {
-			log.Fatalf("Unable to retrieve user's calendar: %v", err)
+			return nil, err
 		}
 
-		// Match calendar name; collect all names for diagnostics
-		for _, item := range listCal.Items {
-			if item.Summary == *calendarName {
-				return item.Id
-			}
+		report.Merge(r)
+	}
 
-			availableNames = append(availableNames, item.Summary)
-		}
+	for _, err := range report.Skipped {
+		slog.Warn("Skipping event", "reason", err)
+	}
 
-		// Handle pagination
-		nextPageToken = listCal.NextPageToken
-		if nextPageToken == "" {
-			break
-		}
+	return report, nil
+}
+
+// openEventStore opens the local event store in the cache directory.
+func openEventStore() (*billing.Store, error) {
+	return billing.OpenStore(filepath.Join(cacheDirFinal, eventStoreFile))
+}
+
+// fetchReport bills events of a report source, synced into the event store unless it is nil. Offline, stored events
+// are billed as last synced.
+func fetchReport(ctx context.Context, store *billing.Store, src calendarSource) (*billing.Report, error) {
+	q := newQuery(src.calendar)
+
+	if store == nil {
+		return billing.Fetch(ctx, src.srv, q)
+	}
+
+	if err := syncSource(ctx, store, src, q); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Available calendars: %s", strings.Join(availableNames, ", "))
+	r, err := store.Report(src.scope, q)
 
-	return ""
+	return r, notSyncedError(src, err)
 }
 
-// getCalendarEvents gets all calendar events for a calendar ID and a date range.
-func getCalendarEvents(ctx context.Context, srv *calendar.Service, calendarName *string) map[string]workEvent {
-	// Fetch calendar ID
-	calID := getCalendarID(ctx, srv, calendarName)
-	if calID == "" {
-		log.Fatalf("Unable to find calendar ID for %q", *calendarName)
-	}
-
-	// Allocate empty map structure corresponding to calendar events
-	eventMap := make(map[string]workEvent)
-
-	nextPageToken := ""
-
-	// Hoist loop-invariant values outside the pagination loop
-	loc := time.Local
-	timeMin := startDateFinal.Format(time.RFC3339)
-	timeMax := endDateFinal.Format(time.RFC3339)
-	includeRecurringLocal := *includeRecurring
-	searchStringLocal := *searchString
-
-	// Get all calendar events within specified date range (paginated)
-	for {
-		eventsCall := srv.Events.List(calID).
-			ShowDeleted(false).
-			SingleEvents(true).
-			TimeMin(timeMin).
-			TimeMax(timeMax).
-			MaxResults(calendarMaxResults).
-			OrderBy("startTime").
-			PageToken(nextPageToken).
-			Context(ctx)
-
-		events, err := eventsCall.Do()
-		if err != nil {
-			log.Fatalf("Unable to retrieve user's events: %v", err)
-		}
+// fetchEvents lists events of a report source in the report period, synced into the event store unless it is nil.
+func fetchEvents(ctx context.Context, store *billing.Store, src calendarSource) ([]*calendar.Event, error) {
+	q := newQuery(src.calendar)
 
-		// Don't parse event if it's recurring event
-		for _, item := range events.Items {
-			if !includeRecurringLocal && item.RecurringEventId != "" {
-				continue
-			}
+	if store == nil {
+		return billing.ListEvents(ctx, src.srv, q)
+	}
 
-			// Start/End are *EventDateTime pointers; skip rather than panic if absent
-			if item.Start == nil || item.End == nil {
-				continue
-			}
+	if err := syncSource(ctx, store, src, q); err != nil {
+		return nil, err
+	}
 
-			start := item.Start.DateTime
-			if start == "" {
-				start = item.Start.Date
-			}
+	items, err := store.Events(src.scope, q)
 
-			end := item.End.DateTime
-			if end == "" {
-				end = item.End.Date
-			}
+	return items, notSyncedError(src, err)
+}
 
-			// Trim event description/summary whitespace
-			desc := strings.TrimSpace(item.Description)
-			if desc == "" {
-				desc = strings.TrimSpace(item.Summary)
-			}
+// initialSync fully syncs report sources that were never synced into the event store, or all of them with
+// --resync. A full sync lists the whole calendar history, so it is bounded by the sync timeout instead of the report
+// timeout, and later reports only sync changes.
+func initialSync(ctx context.Context, sources []calendarSource) error {
+	if cacheDirFinal == "" || offlineFinal {
+		return nil
+	}
+
+	store, err := openEventStore()
+	if err != nil {
+		return err
+	}
 
-			// Match prefix string if requested
-			if searchStringLocal != "" {
-				if !strings.HasPrefix(desc, searchStringLocal) {
-					continue
-				}
+	defer func() { _ = store.Close() }()
 
-				desc = strings.TrimSpace(strings.TrimPrefix(desc, searchStringLocal))
+	for _, src := range sources {
+		if *resyncFlag {
+			if err := store.Reset(src.scope, src.calendar); err != nil {
+				return err
 			}
+		}
+
+		synced, err := store.Synced(src.scope, src.calendar)
+		if err != nil {
+			return err
+		}
 
-			// Parse individual event and update calendar event map
-			eventMap = parseCalendarEvent(desc, start, end, loc, eventMap)
+		if synced {
+			continue
 		}
 
-		// Handle pagination
-		nextPageToken = events.NextPageToken
-		if nextPageToken == "" {
-			break
+		name := cmp.Or(src.calendar, billing.PrimaryCalendar)
+		slog.Info("Syncing all events into the local event store, this may take a while", "calendar", name,
+			"scope", src.scope)
+
+		syncCtx, cancel := context.WithTimeout(ctx, *syncTimeout)
+		err = syncSource(syncCtx, store, src, newQuery(src.calendar))
+
+		cancel()
+
+		if err != nil {
+			if errors.Is(syncCtx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w: first sync of calendar %s did not finish within %v, retry with a longer "+
+					"--sync-timeout or use --no-cache", ErrAPITimeout, name, *syncTimeout)
+			}
+
+			return err
 		}
 	}
 
-	return eventMap
+	return nil
 }
 
-// parseCalendarEvent parses individual calendar events and returns map with cumulative event hours per day and
-// concatenated event descriptions.
-func parseCalendarEvent(desc, start, end string, loc *time.Location, eventMap map[string]workEvent) map[string]workEvent {
-	// Parse event starting time in RFC3339 (recurring events do not comply)
-	startTime, err := time.ParseInLocation(time.RFC3339, start, loc)
-	if err != nil {
-		log.Printf("Skipping event %q: unable to parse start time %q (all-day events without a time component are not supported)", desc, start)
-		return eventMap
+// syncSource brings stored events of a report source up to date, unless offline.
+func syncSource(ctx context.Context, store *billing.Store, src calendarSource, q billing.Query) error {
+	if offlineFinal {
+		return nil
 	}
 
-	// Parse event ending time in RFC3339 (recurring events do not comply)
-	endTime, err := time.ParseInLocation(time.RFC3339, end, loc)
+	res, err := store.Sync(ctx, src.srv, src.scope, q)
 	if err != nil {
-		log.Printf("Skipping event %q: unable to parse end time %q (all-day events without a time component are not supported)", desc, end)
-		return eventMap
+		return err
 	}
 
-	dateKey := startTime.Format(dateLayout) // Starting time is an event key
-	workDuration := endTime.Sub(startTime)
-	hours := int(math.Ceil(workDuration.Hours())) // Bill partial hours as full hours
+	slog.Debug("Synced calendar", "calendar", cmp.Or(src.calendar, billing.PrimaryCalendar), "scope", src.scope,
+		"updated", res.Updated, "deleted", res.Deleted, "full", res.Full)
 
-	// Update calendar event map with either adding work hours or creating a new entry
-	if temp, ok := eventMap[dateKey]; ok {
-		temp.hoursTotal += hours
-		temp.workDesc.WriteString(", ")
-		temp.workDesc.WriteString(desc)
-		eventMap[dateKey] = temp
-	} else {
-		b := &strings.Builder{}
-		b.WriteString(desc)
-		eventMap[dateKey] = workEvent{workDesc: b, hoursTotal: hours}
+	return nil
+}
+
+// notSyncedError explains how to fix a calendar that was never synced, which only happens offline.
+func notSyncedError(src calendarSource, err error) error {
+	if errors.Is(err, billing.ErrNotSynced) {
+		return fmt.Errorf("%w of %s, run once without --offline first", err, src.scope)
 	}
 
-	return eventMap
+	return err
 }
 
-// printMonthlyStats displays final monthly calendar statistics.
-func printMonthlyStats(eventMap map[string]workEvent, holidayMap map[string]holidayEvent) {
-	calName := *calendarName
-	if calName == "" {
-		calName = "primary"
+// reportCalendarName returns the report calendar name: the selected calendar, or all calendars of named accounts.
+func reportCalendarName() string {
+	if len(accountCalendarsFinal) > 0 {
+		names := make([]string, 0, len(accountCalendarsFinal))
+		for _, ac := range accountCalendarsFinal {
+			names = append(names, ac.String())
+		}
+
+		return strings.Join(names, ", ")
 	}
 
-	fmt.Printf("Listing work done on %v project from %v to %v\n", calName,
-		startDateFinal.Format(dateLayout), endDateFinal.Format(dateLayout))
+	if *calendarName == "" {
+		return billing.PrimaryCalendar
+	}
 
-	eventKeys := make([]string, len(eventMap))
-	dayCount := 0
+	return *calendarName
+}
 
-	// Create temporary sorted slice for sorted map access
-	for k := range eventMap {
-		eventKeys[dayCount] = k
-		dayCount++
-	}
+// snapshotTime formats the time of the oldest calendar sync an offline report was built from.
+func snapshotTime(report *billing.Report) string {
+	return report.SyncedAt.Local().Format(time.DateTime)
+}
 
-	slices.Sort(eventKeys)
+// printMonthlyStats displays final monthly calendar statistics. A non-nil holidayErr is shown as a note, since an empty
+// holiday overlap list is otherwise indistinguishable from a failed holiday lookup.
+func printMonthlyStats(report *billing.Report, holidayErr error) {
+	fmt.Printf("Listing work done on %v project from %v to %v\n", reportCalendarName(),
+		report.Query.Start.Format(billing.DateLayout), report.Query.End.Format(billing.DateLayout))
 
-	var totalHours int
+	if offlineFinal {
+		fmt.Printf("Offline snapshot of calendar events synced at %v\n", snapshotTime(report))
+	}
 
 	// Dash or classic output format; single loop, format strings kept constant
 	// so the vet printf analyzer can verify them
@@ -237,88 +247,270 @@ func printMonthlyStats(eventMap map[string]workEvent, holidayMap map[string]holi
 		fmt.Printf("%10s\tHr\tDescription\n", "Date")
 	}
 
-	for _, k := range eventKeys {
-		v := eventMap[k]
+	dates := report.Dates()
+
+	for _, k := range dates {
+		v := report.Days[k]
 
 		if *dashFlag {
-			fmt.Printf("%10s - %dh - %s\n", k, v.hoursTotal, v.workDesc.String())
+			fmt.Printf("%10s - %dh - %s\n", k, v.Hours, v.Description())
 		} else {
-			fmt.Printf("%10s\t%2d\t%s\n", k, v.hoursTotal, v.workDesc.String())
+			fmt.Printf("%10s\t%2d\t%s\n", k, v.Hours, v.Description())
 		}
-
-		totalHours += v.hoursTotal
 	}
 
 	// Total cumulative statistics
-	fmt.Printf("\nTotal workhour sum for given period:\t\t%d hours\nTotal active days for given period:\t\t%d days\n", totalHours, dayCount)
+	fmt.Printf("\nTotal workhour sum for given period:\t\t%d hours\nTotal active days for given period:\t\t%d days\n",
+		report.TotalHours(), len(dates))
 
-	var holidayKeys []string
-
-	// Attempt to identify event overlap with public holidays
-	for k := range holidayMap {
-		if _, ok := eventMap[k]; ok {
-			holidayKeys = append(holidayKeys, k)
-		}
-	}
+	// Weekend and after-hours work with surcharged billed amount
+	printSurchargeStats(report)
 
 	// Display event overlap with holidays only if we have any results
-	if len(holidayKeys) > 0 {
-		slices.Sort(holidayKeys)
-
+	if holidayKeys := report.HolidayDates(); len(holidayKeys) > 0 {
 		fmt.Printf("\nYou have calendar events on following public holidays:\n")
 
 		for _, k := range holidayKeys {
-			fmt.Printf("%10s\t%v\n", k, holidayMap[k].holidayDesc)
+			fmt.Printf("%10s\t%v\n", k, report.Holidays[k].Description)
 		}
 	}
+
+	if lines := holidayErrorLines(holidayErr); len(lines) > 0 {
+		fmt.Printf("\n")
+
+		for _, line := range lines {
+			fmt.Printf("Note: holiday check unavailable: %s\n", line)
+		}
+	}
+}
+
+// Holiday sources selectable by --holiday-source.
+const (
+	holidaySourceRemote  = "remote"
+	holidaySourceBuiltin = "builtin"
+)
+
+// holidayOptions configures where public holidays are taken from.
+type holidayOptions struct {
+	start, end time.Time
+	httpClient *http.Client // shared HTTP client for GeoIP and ICS requests
+	source     string
+	cacheDir   string // on-disk HTTP response cache directory, empty disables caching
+	offline    bool   // serve cached GeoIP and ICS responses of any age instead of fetching them
+	geoipDB    string // MaxMind .mmdb database path for the maxmind GeoIP provider
+	countries  []string
+	icsFiles   []string
+	geoipOrder []string // GeoIP provider names in fallback order
 }
 
-// getHolidayEvents does public IP geolocation (ifconfig.co), identifies country ISO code and gets holiday ICS for this country.
-func getHolidayEvents(ctx context.Context) map[string]holidayEvent {
-	holidayMap := make(map[string]holidayEvent)
+// maxHolidayErrLen is a maximum length of a single holiday error line in report notes.
+const maxHolidayErrLen = 160
+
+// getHolidayEvents gets public holidays from local ICS files when configured, otherwise for each configured country
+// ISO code from either officeholidays.com ICS or built-in holiday rules. When no country is configured, it does
+// public IP geolocation to identify the country ISO code. Holidays from all sources that succeeded are
+// returned together with a joined error of all sources that failed, so a failed lookup is distinguishable from a
+// period without holidays.
+func getHolidayEvents(ctx context.Context, opts holidayOptions) (map[string]billing.Holiday, error) {
+	holidayMap := make(map[string]billing.Holiday)
+
+	var errs []error
+
+	// Local ICS files need neither geolocation nor a country code
+	if len(opts.icsFiles) > 0 {
+		multiFile := len(opts.icsFiles) > 1
+
+		for _, path := range opts.icsFiles {
+			cal, err := getLocalHolidays(ctx, path)
+			if err != nil {
+				slog.Debug("Holiday lookup failed", "source", "ics", "path", path, "error", err)
+				errs = append(errs, fmt.Errorf("ICS file %s: %w", path, err))
+
+				continue
+			}
+
+			slog.Debug("Holiday lookup", "source", "ics", "path", path, "holidays", len(cal))
 
-	func() {
-		ctxGeoip, cancelGeoip := context.WithTimeout(ctx, geoip.DefaultTimeout)
-		defer cancelGeoip()
+			mergeHolidayEvents(holidayMap, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), cal, multiFile)
+		}
+
+		return holidayMap, errors.Join(errs...)
+	}
 
-		// Initialize GeoIP/ifconfig HTTP client
-		ifconfigClient, err := geoip.NewClient()
+	countries := opts.countries
+
+	// GeoIP detection is only a fallback for a missing explicit configuration
+	if len(countries) == 0 {
+		countryISO, err := getGeoIPCountry(ctx, opts)
 		if err != nil {
-			return
+			return holidayMap, err
+		}
+
+		countries = []string{countryISO}
+	}
+
+	// Tag holidays with their country only when several countries are merged
+	multiCountry := len(countries) > 1
+
+	for _, countryISO := range countries {
+		var (
+			cal ics.Events
+			err error
+		)
+
+		if opts.source == holidaySourceBuiltin {
+			cal, err = getBuiltinHolidays(countryISO, opts.start, opts.end)
+			if err != nil {
+				err = fmt.Errorf("built-in rules for %s: %w", countryISO, err)
+			}
+		} else {
+			cal, err = getRemoteHolidays(ctx, countryISO, opts)
+			if err != nil {
+				err = fmt.Errorf("officeholidays for %s: %w", countryISO, err)
+			}
 		}
 
-		// Fetch and parse JSON from ifconfig
-		geoIP, err := ifconfigClient.GetResponse(ctxGeoip)
 		if err != nil {
-			return
+			slog.Debug("Holiday lookup failed", "source", opts.source, "country", countryISO, "error", err)
+			errs = append(errs, err)
+
+			continue
 		}
 
-		// Use ISO 3166-1 country code for ICS lookup
-		if geoIP.CountryISO == "" {
-			return
+		slog.Debug("Holiday lookup", "source", opts.source, "country", countryISO, "holidays", len(cal))
+		mergeHolidayEvents(holidayMap, countryISO, cal, multiCountry)
+	}
+
+	return holidayMap, errors.Join(errs...)
+}
+
+// holidayErrorLines flattens a (possibly joined) holiday error into short single-line messages, dropping verbose
+// multi-line HTTP error bodies.
+func holidayErrorLines(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	lines := make([]string, 0, len(errs))
+
+	for _, e := range errs {
+		line, _, _ := strings.Cut(e.Error(), "\n")
+		line = strings.TrimSpace(line)
+
+		if r := []rune(line); len(r) > maxHolidayErrLen {
+			line = string(r[:maxHolidayErrLen]) + "..."
 		}
 
-		ctxIcs, cancelIcs := context.WithTimeout(ctx, ics.DefaultTimeout)
-		defer cancelIcs()
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// getRemoteHolidays fetches and parses officeholidays.com ICS for a country ISO code.
+func getRemoteHolidays(ctx context.Context, countryISO string, opts holidayOptions) (ics.Events, error) {
+	ctxIcs, cancelIcs := context.WithTimeout(ctx, ics.DefaultTimeout)
+	defer cancelIcs()
+
+	// Initialize ICS HTTP client
+	icsClient, err := ics.NewClient(countryISO, ics.WithHTTPClient(opts.httpClient))
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.cacheDir != "" {
+		icsClient.Cache = cache.New(opts.cacheDir, ics.DefaultCacheTTL)
+		icsClient.Cache.Offline = opts.offline
+	}
+
+	// Fetch and parse ICS response
+	return icsClient.GetResponse(ctxIcs)
+}
+
+// getLocalHolidays reads and parses a local ICS file.
+func getLocalHolidays(ctx context.Context, path string) (ics.Events, error) {
+	icsClient, err := ics.NewFileClient(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return icsClient.GetResponse(ctx)
+}
+
+// getBuiltinHolidays generates holidays from built-in rules for all years in a date range.
+func getBuiltinHolidays(countryISO string, start, end time.Time) (ics.Events, error) {
+	hs, err := holidays.Generate(countryISO, start.Year(), end.Year())
+	if err != nil {
+		return nil, err
+	}
+
+	cal := make(ics.Events, 0, len(hs))
+
+	for _, h := range hs {
+		cal = append(cal, ics.Event{
+			Start:   h.Date,
+			End:     h.Date.AddDate(0, 0, 1),
+			ID:      countryISO + "-" + h.Date.Format(billing.DateLayout),
+			Summary: h.Name,
+		})
+	}
+
+	return cal, nil
+}
 
-		// Initialize ICS HTTP client
-		icsClient, err := ics.NewClient(geoIP.CountryISO)
+// getGeoIPCountry does public IP geolocation through GeoIP providers in configured order and returns ISO 3166-1
+// country code of the first provider that returns one.
+func getGeoIPCountry(ctx context.Context, opts holidayOptions) (string, error) {
+	providers := make([]geoip.Provider, 0, len(opts.geoipOrder))
+
+	for _, name := range opts.geoipOrder {
+		p, err := geoip.NewProvider(name, opts.geoipDB, geoip.WithHTTPClient(opts.httpClient))
 		if err != nil {
-			return
+			return "", fmt.Errorf("GeoIP lookup: %w", err)
 		}
 
-		// Fetch and parse ICS response
-		cal, err := icsClient.GetResponse(ctxIcs)
-		if err != nil {
-			return
+		// Only JSON API responses are cached; the public IP for local database lookups may change any time
+		if c, ok := p.(*geoip.Client); ok && opts.cacheDir != "" {
+			c.Cache = cache.New(opts.cacheDir, geoip.DefaultCacheTTL)
+			c.Cache.Offline = opts.offline
+		}
+
+		providers = append(providers, p)
+	}
+
+	// Fetch and parse GeoIP responses until one has a country ISO code
+	geoIP, err := geoip.Lookup(ctx, providers)
+	if err != nil {
+		slog.Debug("GeoIP lookup failed", "providers", opts.geoipOrder, "error", err)
+
+		return "", fmt.Errorf("GeoIP lookup: %w", err)
+	}
+
+	slog.Debug("GeoIP lookup", "country", geoIP.CountryISO)
+
+	return geoIP.CountryISO, nil
+}
+
+// mergeHolidayEvents adds country holiday events to holiday map, concatenating holidays on the same date. With
+// multiple countries, descriptions are tagged with a country code.
+func mergeHolidayEvents(holidayMap map[string]billing.Holiday, countryISO string, cal ics.Events, multiCountry bool) {
+	for _, event := range cal {
+		shortDate := event.Start.Format(billing.DateLayout)
+
+		desc := event.Summary
+		if multiCountry {
+			desc = fmt.Sprintf("%s (%s)", desc, countryISO)
 		}
 
-		// Extract all holiday events
-		for _, event := range cal {
-			shortDate := event.Start.Format(dateLayout)
-			holidayMap[shortDate] = holidayEvent{holidayDesc: event.Summary}
+		if prev, ok := holidayMap[shortDate]; ok {
+			desc = prev.Description + ", " + desc
 		}
-	}()
 
-	return holidayMap
+		holidayMap[shortDate] = billing.Holiday{Description: desc}
+	}
 }
@@ -6,253 +6,371 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/dkorunic/IM-billing-v2/holidays"
+	"github.com/dkorunic/IM-billing-v2/ics"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
 )
 
-// fixed start time used across rounding sub-tests.
-const roundingStart = "2024-01-15T09:00:00+00:00"
+// TC-08: printMonthlyStats must warn only for holidays that overlap with work events.
+func TestPrintMonthlyStats_HolidayOverlapDetection(t *testing.T) {
+	origCalendarName := calendarName
+	origDashFlag := dashFlag
+	origStart := startDateFinal
+	origEnd := endDateFinal
 
-// descBuilder wraps a string in a *strings.Builder for workEvent literals.
-func descBuilder(s string) *strings.Builder {
-	b := &strings.Builder{}
-	b.WriteString(s)
+	t.Cleanup(func() {
+		calendarName = origCalendarName
+		dashFlag = origDashFlag
+		startDateFinal = origStart
+		endDateFinal = origEnd
+	})
 
-	return b
-}
+	calName := "TestCal"
+	calendarName = &calName
 
-func TestParseCalendarEvent_NewEvent(t *testing.T) {
-	eventMap := make(map[string]workEvent)
+	dash := false
+	dashFlag = &dash
 
-	result := parseCalendarEvent(
-		"Work on project",
-		"2024-01-15T09:00:00+00:00",
-		"2024-01-15T17:00:00+00:00",
-		time.UTC,
-		eventMap,
-	)
+	setSurchargeGlobals(t, 0, 1, 1, 1, 1)
 
-	if len(result) != 1 {
-		t.Fatalf("expected 1 event, got %d", len(result))
-	}
+	startDateFinal = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	endDateFinal = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	report := billing.NewReport(billing.Query{Start: startDateFinal, End: endDateFinal})
+	report.Days["2024-01-15"] = billing.NewDay("Holiday work", 8, 0)
+	report.Days["2024-01-20"] = billing.NewDay("Normal work", 8, 0)
+	report.Holidays["2024-01-15"] = billing.Holiday{Description: "Public Holiday"}  // overlap: work event exists
+	report.Holidays["2024-01-25"] = billing.Holiday{Description: "Another Holiday"} // no overlap: no work event
+
+	output := captureStdout(t, func() { printMonthlyStats(report, nil) })
 
-	ev, ok := result["2024-01-15"]
-	if !ok {
-		t.Fatal("key 2024-01-15 not found in result map")
+	const overlapHeader = "You have calendar events on following public holidays:"
+
+	overlapIdx := strings.Index(output, overlapHeader)
+	if overlapIdx == -1 {
+		t.Fatalf("holiday overlap section not found in output:\n%s", output)
 	}
 
-	if ev.hoursTotal != 8 {
-		t.Errorf("hoursTotal: got %d, want 8", ev.hoursTotal)
+	overlapSection := output[overlapIdx:]
+
+	if !strings.Contains(overlapSection, "2024-01-15") {
+		t.Errorf("2024-01-15 (worked on a holiday) must appear in overlap section; output:\n%s", output)
 	}
 
-	if ev.workDesc.String() != "Work on project" {
-		t.Errorf("workDesc: got %q, want %q", ev.workDesc.String(), "Work on project")
+	if strings.Contains(overlapSection, "2024-01-25") {
+		t.Errorf("2024-01-25 (holiday with no work event) must not appear in overlap section; output:\n%s", output)
 	}
 }
 
-func TestParseCalendarEvent_AccumulateSameDay(t *testing.T) {
-	eventMap := make(map[string]workEvent)
+func TestMergeHolidayEvents_SingleCountry(t *testing.T) {
+	holidayMap := make(map[string]billing.Holiday)
 
-	eventMap = parseCalendarEvent("Morning", "2024-01-15T09:00:00+00:00", "2024-01-15T13:00:00+00:00", time.UTC, eventMap)
-	eventMap = parseCalendarEvent("Afternoon", "2024-01-15T14:00:00+00:00", "2024-01-15T18:00:00+00:00", time.UTC, eventMap)
+	mergeHolidayEvents(holidayMap, "HR", ics.Events{
+		{Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Summary: "New Year's Day"},
+	}, false)
 
-	if len(eventMap) != 1 {
-		t.Fatalf("expected 1 map entry, got %d", len(eventMap))
+	if got := holidayMap["2024-01-01"].Description; got != "New Year's Day" {
+		t.Errorf("Description: got %q, want %q", got, "New Year's Day")
 	}
+}
+
+func TestMergeHolidayEvents_MultiCountry(t *testing.T) {
+	holidayMap := make(map[string]billing.Holiday)
+	newYear := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	ev := eventMap["2024-01-15"]
+	mergeHolidayEvents(holidayMap, "HR", ics.Events{{Start: newYear, Summary: "Nova godina"}}, true)
+	mergeHolidayEvents(holidayMap, "DE", ics.Events{
+		{Start: newYear, Summary: "Neujahr"},
+		{Start: time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC), Summary: "Tag der Deutschen Einheit"},
+	}, true)
 
-	if ev.hoursTotal != 8 {
-		t.Errorf("hoursTotal: got %d, want 8", ev.hoursTotal)
+	if got, want := holidayMap["2024-01-01"].Description, "Nova godina (HR), Neujahr (DE)"; got != want {
+		t.Errorf("Description: got %q, want %q", got, want)
 	}
 
-	desc := ev.workDesc.String()
-	if !strings.Contains(desc, "Morning") || !strings.Contains(desc, "Afternoon") {
-		t.Errorf("workDesc missing expected parts: %q", desc)
+	if got, want := holidayMap["2024-10-03"].Description, "Tag der Deutschen Einheit (DE)"; got != want {
+		t.Errorf("Description: got %q, want %q", got, want)
 	}
 }
 
-func TestParseCalendarEvent_DifferentDays(t *testing.T) {
-	eventMap := make(map[string]workEvent)
-
-	eventMap = parseCalendarEvent("Day1", "2024-01-15T09:00:00+00:00", "2024-01-15T17:00:00+00:00", time.UTC, eventMap)
-	eventMap = parseCalendarEvent("Day2", "2024-01-16T09:00:00+00:00", "2024-01-16T17:00:00+00:00", time.UTC, eventMap)
+func TestGetHolidayEvents_Builtin(t *testing.T) {
+	holidayMap, err := getHolidayEvents(context.Background(), holidayOptions{
+		start:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		end:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local),
+		source:    holidaySourceBuiltin,
+		countries: []string{"HR"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-	if len(eventMap) != 2 {
-		t.Fatalf("expected 2 map entries, got %d", len(eventMap))
+	if got, want := holidayMap["2024-04-01"].Description, "Easter Monday"; got != want {
+		t.Errorf("2024-04-01: got %q, want %q", got, want)
 	}
 
-	if _, ok := eventMap["2024-01-15"]; !ok {
-		t.Error("key 2024-01-15 not found")
+	// Same-date holidays must be concatenated, not overwritten
+	if got, want := holidayMap["2024-05-30"].Description, "Statehood Day, Corpus Christi"; got != want {
+		t.Errorf("2024-05-30: got %q, want %q", got, want)
 	}
 
-	if _, ok := eventMap["2024-01-16"]; !ok {
-		t.Error("key 2024-01-16 not found")
+	if _, ok := holidayMap["2025-01-01"]; !ok {
+		t.Error("2025-01-01: holidays for the end date year must be generated")
 	}
 }
 
-func TestParseCalendarEvent_InvalidStart(t *testing.T) {
-	eventMap := make(map[string]workEvent)
+func TestGetHolidayEvents_LocalICS(t *testing.T) {
+	const localICS = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//Test//EN
+BEGIN:VEVENT
+UID:holiday-1@test
+DTSTART;VALUE=DATE:20240101
+DTEND;VALUE=DATE:20240102
+SUMMARY:New Year's Day
+END:VEVENT
+END:VCALENDAR
+`
+
+	path := filepath.Join(t.TempDir(), "hr.ics")
+
+	if err := os.WriteFile(path, []byte(localICS), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// Country and source must be ignored when ICS files are configured
+	holidayMap, err := getHolidayEvents(context.Background(), holidayOptions{
+		source:    holidaySourceRemote,
+		countries: []string{"DE"},
+		icsFiles:  []string{path},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-	result := parseCalendarEvent("Work", "not-a-date", "2024-01-15T17:00:00+00:00", time.UTC, eventMap)
+	if len(holidayMap) != 1 {
+		t.Fatalf("expected 1 holiday, got %d", len(holidayMap))
+	}
 
-	if len(result) != 0 {
-		t.Errorf("expected empty map for invalid start, got %d entries", len(result))
+	if got, want := holidayMap["2024-01-01"].Description, "New Year's Day"; got != want {
+		t.Errorf("2024-01-01: got %q, want %q", got, want)
 	}
 }
 
-func TestParseCalendarEvent_InvalidEnd(t *testing.T) {
-	eventMap := make(map[string]workEvent)
+// Failed holiday sources must be reported as errors, while holidays from sources that succeeded are kept.
+func TestGetHolidayEvents_PartialFailure(t *testing.T) {
+	holidayMap, err := getHolidayEvents(context.Background(), holidayOptions{
+		start:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		end:       time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local),
+		source:    holidaySourceBuiltin,
+		countries: []string{"HR", "XX"},
+	})
 
-	result := parseCalendarEvent("Work", "2024-01-15T09:00:00+00:00", "not-a-date", time.UTC, eventMap)
+	if !errors.Is(err, holidays.ErrUnsupportedCountry) {
+		t.Errorf("expected ErrUnsupportedCountry, got %v", err)
+	}
 
-	if len(result) != 0 {
-		t.Errorf("expected empty map for invalid end, got %d entries", len(result))
+	if _, ok := holidayMap["2024-01-01"]; !ok {
+		t.Error("holidays from a successful source must be kept on partial failure")
 	}
 }
 
-func TestParseCalendarEvent_HourRounding(t *testing.T) {
-	tests := []struct {
-		name      string
-		end       string
-		wantHours int
-	}{
-		// 2h00m
-		{"exact two hours", "2024-01-15T11:00:00+00:00", 2},
-		// 2h30m
-		{"half hour rounds up", "2024-01-15T11:30:00+00:00", 3},
-		// 2h01m
-		{"one minute rounds up", "2024-01-15T11:01:00+00:00", 3},
-		// 2h59m
-		{"below next hour rounds up", "2024-01-15T11:59:00+00:00", 3},
-	}
+func TestHolidayErrorLines(t *testing.T) {
+	err := errors.Join(
+		errors.New("officeholidays for HR: HTTP 503: <html>\n<body>down</body>\n</html>"),
+		errors.New(strings.Repeat("x", maxHolidayErrLen+10)),
+	)
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			eventMap := make(map[string]workEvent)
+	lines := holidayErrorLines(err)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), lines)
+	}
 
-			result := parseCalendarEvent("Work", roundingStart, tc.end, time.UTC, eventMap)
+	if got, want := lines[0], "officeholidays for HR: HTTP 503: <html>"; got != want {
+		t.Errorf("line 0: got %q, want %q", got, want)
+	}
 
-			ev, ok := result["2024-01-15"]
-			if !ok {
-				t.Fatal("key 2024-01-15 not found")
-			}
+	if got := len([]rune(lines[1])); got != maxHolidayErrLen+3 {
+		t.Errorf("line 1 length: got %d, want %d (truncated with ellipsis)", got, maxHolidayErrLen+3)
+	}
 
-			if ev.hoursTotal != tc.wantHours {
-				t.Errorf("hoursTotal: got %d, want %d", ev.hoursTotal, tc.wantHours)
-			}
-		})
+	if holidayErrorLines(nil) != nil {
+		t.Error("expected no lines for nil error")
 	}
 }
 
-// TC-03: map key must come from start time, not end time.
-// An event crossing midnight must be bucketed under the start date.
-func TestParseCalendarEvent_DateKeyFromStartNotEnd(t *testing.T) {
-	eventMap := make(map[string]workEvent)
-
-	result := parseCalendarEvent(
-		"Late work",
-		"2024-01-15T23:00:00+00:00",
-		"2024-01-16T01:00:00+00:00",
-		time.UTC,
-		eventMap,
-	)
+// captureStdout runs fn and returns everything it wrote to standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
 
-	if _, ok := result["2024-01-15"]; !ok {
-		t.Error("expected key 2024-01-15 (start date) but it was absent")
+	rPipe, wPipe, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if _, ok := result["2024-01-16"]; ok {
-		t.Error("key 2024-01-16 (end date) must not be used as the event key")
+	origStdout := os.Stdout
+	os.Stdout = wPipe
+
+	fn()
+
+	wPipe.Close()
+	os.Stdout = origStdout
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, rPipe); err != nil {
+		t.Fatal(err)
 	}
+
+	rPipe.Close()
+
+	return buf.String()
 }
 
-// TC-08: printMonthlyStats must warn only for holidays that overlap with work events.
-func TestPrintMonthlyStats_HolidayOverlapDetection(t *testing.T) {
+func TestPrintMonthlyStats_HolidayErrorNote(t *testing.T) {
 	origCalendarName := calendarName
 	origDashFlag := dashFlag
-	origStart := startDateFinal
-	origEnd := endDateFinal
 
 	t.Cleanup(func() {
 		calendarName = origCalendarName
 		dashFlag = origDashFlag
-		startDateFinal = origStart
-		endDateFinal = origEnd
 	})
 
-	calName := "TestCal"
+	calName := ""
 	calendarName = &calName
 
 	dash := false
 	dashFlag = &dash
 
-	startDateFinal = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
-	endDateFinal = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	setSurchargeGlobals(t, 0, 1, 1, 1, 1)
 
-	eventMap := map[string]workEvent{
-		"2024-01-15": {workDesc: descBuilder("Holiday work"), hoursTotal: 8},
-		"2024-01-20": {workDesc: descBuilder("Normal work"), hoursTotal: 8},
-	}
-	holidayMap := map[string]holidayEvent{
-		"2024-01-15": {holidayDesc: "Public Holiday"},  // overlap: work event exists
-		"2024-01-25": {holidayDesc: "Another Holiday"}, // no overlap: no work event
+	output := captureStdout(t, func() {
+		printMonthlyStats(billing.NewReport(billing.Query{}),
+			errors.New("officeholidays for HR: HTTP 503: Service Unavailable"))
+	})
+
+	const want = "Note: holiday check unavailable: officeholidays for HR: HTTP 503: Service Unavailable"
+	if !strings.Contains(output, want) {
+		t.Errorf("holiday error note not found in output:\n%s", output)
 	}
+}
 
-	rPipe, wPipe, err := os.Pipe()
+func TestFetchReport_OfflineNotSynced(t *testing.T) {
+	origSearch := searchString
+	origRecurring := includeRecurring
+	origVerbose := verboseFlag
+	origExplain := explainFlag
+	origOffline := offlineFinal
+
+	t.Cleanup(func() {
+		searchString = origSearch
+		includeRecurring = origRecurring
+		verboseFlag = origVerbose
+		explainFlag = origExplain
+		offlineFinal = origOffline
+	})
+
+	search, off := "", false
+	searchString = &search
+	includeRecurring = &off
+	verboseFlag = &off
+	explainFlag = &off
+	offlineFinal = true
+
+	store, err := billing.OpenStore(filepath.Join(t.TempDir(), eventStoreFile))
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("OpenStore: %v", err)
 	}
 
-	origStdout := os.Stdout
-	os.Stdout = wPipe
+	defer func() { _ = store.Close() }()
 
-	printMonthlyStats(eventMap, holidayMap)
+	// Offline, a calendar never synced is an error instead of a Calendar API request through a nil client
+	_, err = fetchReport(context.Background(), store, calendarSource{calendar: "Work", scope: "default"})
+	if !errors.Is(err, billing.ErrNotSynced) || !strings.Contains(err.Error(), "--offline") {
+		t.Fatalf("error: got %v, want ErrNotSynced with a hint to sync online", err)
+	}
+}
 
-	wPipe.Close()
-	os.Stdout = origStdout
+// setInitialSyncGlobals sets the flag globals read by initialSync, with an event store in a test directory.
+func setInitialSyncGlobals(t *testing.T, timeout time.Duration) {
+	t.Helper()
 
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, rPipe); err != nil {
-		t.Fatal(err)
-	}
+	origSearch, origRecurring, origExplain := searchString, includeRecurring, explainFlag
+	origResync, origSyncTimeout := resyncFlag, syncTimeout
+	origCacheDir, origOffline := cacheDirFinal, offlineFinal
 
-	rPipe.Close()
+	t.Cleanup(func() {
+		searchString, includeRecurring, explainFlag = origSearch, origRecurring, origExplain
+		resyncFlag, syncTimeout = origResync, origSyncTimeout
+		cacheDirFinal, offlineFinal = origCacheDir, origOffline
+	})
 
-	output := buf.String()
+	search, off := "", false
+	searchString, includeRecurring, explainFlag, resyncFlag = &search, &off, &off, &off
+	syncTimeout = &timeout
+	cacheDirFinal, offlineFinal = t.TempDir(), false
+}
 
-	const overlapHeader = "You have calendar events on following public holidays:"
+// newEventsService returns a Calendar API client for a fake server answering event listings with handler.
+func newEventsService(t *testing.T, handler http.HandlerFunc) *calendar.Service {
+	t.Helper()
 
-	overlapIdx := strings.Index(output, overlapHeader)
-	if overlapIdx == -1 {
-		t.Fatalf("holiday overlap section not found in output:\n%s", output)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	svc, err := calendar.NewService(context.Background(), option.WithEndpoint(srv.URL),
+		option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("calendar.NewService: %v", err)
 	}
 
-	overlapSection := output[overlapIdx:]
+	return svc
+}
 
-	if !strings.Contains(overlapSection, "2024-01-15") {
-		t.Errorf("2024-01-15 (worked on a holiday) must appear in overlap section; output:\n%s", output)
+func TestInitialSync(t *testing.T) {
+	setInitialSyncGlobals(t, time.Minute)
+
+	var requests atomic.Int32
+
+	src := calendarSource{scope: "default", srv: newEventsService(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nextSyncToken":"t1","items":[]}`))
+	})}
+
+	for range 2 {
+		if err := initialSync(context.Background(), []calendarSource{src}); err != nil {
+			t.Fatalf("initialSync: %v", err)
+		}
 	}
 
-	if strings.Contains(overlapSection, "2024-01-25") {
-		t.Errorf("2024-01-25 (holiday with no work event) must not appear in overlap section; output:\n%s", output)
+	// Once synced, changes are left to the report sync within the report timeout
+	if got := requests.Load(); got != 1 {
+		t.Errorf("event listings: got %d, want a single full sync", got)
 	}
 }
 
-func TestParseCalendarEvent_DescriptionConcatenation(t *testing.T) {
-	eventMap := make(map[string]workEvent)
-
-	eventMap = parseCalendarEvent("First", "2024-01-15T09:00:00+00:00", "2024-01-15T10:00:00+00:00", time.UTC, eventMap)
-	eventMap = parseCalendarEvent("Second", "2024-01-15T11:00:00+00:00", "2024-01-15T12:00:00+00:00", time.UTC, eventMap)
-	eventMap = parseCalendarEvent("Third", "2024-01-15T13:00:00+00:00", "2024-01-15T14:00:00+00:00", time.UTC, eventMap)
+func TestInitialSync_Timeout(t *testing.T) {
+	setInitialSyncGlobals(t, 50*time.Millisecond)
 
-	ev := eventMap["2024-01-15"]
-	want := "First, Second, Third"
+	src := calendarSource{scope: "default", srv: newEventsService(t, func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})}
 
-	if ev.workDesc.String() != want {
-		t.Errorf("workDesc: got %q, want %q", ev.workDesc.String(), want)
+	err := initialSync(context.Background(), []calendarSource{src})
+	if !errors.Is(err, ErrAPITimeout) || !strings.Contains(err.Error(), "--sync-timeout") {
+		t.Fatalf("error: got %v, want ErrAPITimeout with a hint to raise --sync-timeout", err)
 	}
 }
@@ -0,0 +1,32 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+// explainTimeLayout is the event start layout of explained events.
+const explainTimeLayout = "2006-01-02 15:04"
+
+// printExplanations displays every listed event with its billing decision and reason, and raw and billed durations,
+// so a billed amount can be audited against the calendar.
+func printExplanations(report *billing.Report) {
+	fmt.Printf("Billing decisions on %d listed events:\n", len(report.Explained))
+	fmt.Printf("%-16s\t%-8s\t%8s\tBilled\tEvent\tReason\n", "Start", "Decision", "Raw")
+
+	for _, e := range report.Explained {
+		start := "-"
+		if !e.Start.IsZero() {
+			start = e.Start.Format(explainTimeLayout)
+		}
+
+		fmt.Printf("%-16s\t%-8s\t%8v\t%5dh\t%s\t%s\n", start, e.Decision, e.Raw, e.Billed, e.Summary, e.Reason)
+	}
+
+	fmt.Printf("\n")
+}
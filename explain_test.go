@@ -0,0 +1,39 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+func TestPrintExplanations(t *testing.T) {
+	report := billing.NewReport(billing.Query{})
+	report.Explained = []billing.Explanation{
+		{
+			Start:    time.Date(2024, 1, 15, 9, 0, 0, 0, time.Local),
+			Summary:  "ACME Design review",
+			Reason:   "rounded up from 2h30m0s",
+			Raw:      150 * time.Minute,
+			Billed:   3,
+			Decision: billing.DecisionBilled,
+		},
+		{Summary: "Broken", Reason: "missing start or end time", Decision: billing.DecisionExcluded},
+	}
+
+	output := captureStdout(t, func() { printExplanations(report) })
+
+	for _, want := range []string{
+		"2024-01-15 09:00\tbilled  \t 2h30m0s\t    3h\tACME Design review\trounded up from 2h30m0s\n",
+		"-               \texcluded\t      0s\t    0h\tBroken\tmissing start or end time\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+}
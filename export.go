@@ -0,0 +1,431 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	// formatText is a default plain text report on standard output.
+	formatText = "text"
+
+	// formatXLSX is an Office Open XML spreadsheet report written to a file.
+	formatXLSX = "xlsx"
+
+	// DefaultOutput is a default spreadsheet report file name.
+	DefaultOutput = "IM-billing-v2.xlsx"
+)
+
+// Spreadsheet sheet names; formulas reference them so they must stay in sync.
+const (
+	sheetDays     = "Days"
+	sheetSummary  = "Summary"
+	sheetHolidays = "Holidays"
+)
+
+// summaryRateLabel is the label of the hourly rate input row driving all amounts.
+const summaryRateLabel = "Hourly rate"
+
+// Spreadsheet cell number formats.
+const (
+	numFmtDate   = "yyyy-mm-dd"
+	numFmtHours  = "0"
+	numFmtAmount = "#,##0.00"
+)
+
+// spreadsheetStyles holds style IDs registered with the workbook.
+type spreadsheetStyles struct {
+	header, date, hours, amount int
+}
+
+// summaryInput is a labelled input row of the summary sheet.
+type summaryInput struct {
+	label string
+	value any
+	style int // value cell style, zero keeps the default
+}
+
+// writeSpreadsheet writes monthly calendar statistics as an XLSX workbook with day rows, a formula-driven summary
+// and public holidays, so totals can be adjusted and re-calculated without retyping the data. A non-nil holidayErr
+// is noted on the holiday sheet.
+func writeSpreadsheet(path string, report *billing.Report, holidayErr error) error {
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+
+	styles, err := newSpreadsheetStyles(f)
+	if err != nil {
+		return err
+	}
+
+	// Default sheet is renamed instead of deleted, so the workbook always has a sheet
+	if err := f.SetSheetName("Sheet1", sheetDays); err != nil {
+		return err
+	}
+
+	for _, name := range []string{sheetSummary, sheetHolidays} {
+		if _, err := f.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	inputs := summaryInputs(styles, report)
+
+	lastRow, err := writeDaysSheet(f, styles, report, summaryRateCell(inputs))
+	if err != nil {
+		return err
+	}
+
+	if err := writeSummarySheet(f, styles, report, inputs, lastRow); err != nil {
+		return err
+	}
+
+	if err := writeHolidaysSheet(f, styles, report.Holidays, holidayErr, lastRow); err != nil {
+		return err
+	}
+
+	// Formulas are stored without cached results; ask the spreadsheet application to recalculate on open
+	fullCalc := true
+	if err := f.SetCalcProps(&excelize.CalcPropsOptions{FullCalcOnLoad: &fullCalc}); err != nil {
+		return err
+	}
+
+	summaryIdx, err := f.GetSheetIndex(sheetSummary)
+	if err != nil {
+		return err
+	}
+
+	f.SetActiveSheet(summaryIdx)
+
+	return f.SaveAs(path)
+}
+
+// newSpreadsheetStyles registers header, date and number styles with the workbook.
+func newSpreadsheetStyles(f *excelize.File) (spreadsheetStyles, error) {
+	var (
+		s   spreadsheetStyles
+		err error
+	)
+
+	if s.header, err = f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}}); err != nil {
+		return s, err
+	}
+
+	dateFmt, hoursFmt, amountFmt := numFmtDate, numFmtHours, numFmtAmount
+
+	if s.date, err = f.NewStyle(&excelize.Style{CustomNumFmt: &dateFmt}); err != nil {
+		return s, err
+	}
+
+	if s.hours, err = f.NewStyle(&excelize.Style{CustomNumFmt: &hoursFmt}); err != nil {
+		return s, err
+	}
+
+	if s.amount, err = f.NewStyle(&excelize.Style{CustomNumFmt: &amountFmt}); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// writeDaysSheet writes one row per worked day with an amount formula driven by the summary rate cell, a surcharge
+// day category and after-hours work. It returns the last data row so other sheets can reference the full range.
+func writeDaysSheet(f *excelize.File, styles spreadsheetStyles, report *billing.Report, rateCell string) (int, error) {
+	if err := writeHeader(f, sheetDays, styles.header, "Date", "Hours", "Description", "Amount", "Category",
+		"After hours"); err != nil {
+		return 0, err
+	}
+
+	row := 1
+
+	for _, k := range report.Dates() {
+		v := report.Days[k]
+
+		day, err := time.Parse(billing.DateLayout, k)
+		if err != nil {
+			return 0, fmt.Errorf("invalid event date %q: %w", k, err)
+		}
+
+		row++
+
+		if err := setRow(f, sheetDays, row, day, v.Hours, v.Description()); err != nil {
+			return 0, err
+		}
+
+		if err := f.SetCellFormula(sheetDays, cellName(4, row), fmt.Sprintf("B%d*%s", row, rateCell)); err != nil {
+			return 0, err
+		}
+
+		if err := f.SetCellValue(sheetDays, cellName(5, row), report.Category(k).String()); err != nil {
+			return 0, err
+		}
+
+		if err := f.SetCellValue(sheetDays, cellName(6, row), v.AfterHours); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := setColumnStyles(f, sheetDays, row, styles.date, styles.hours, 0, styles.amount, 0, styles.hours); err != nil {
+		return 0, err
+	}
+
+	if err := f.SetColWidth(sheetDays, "C", "C", 60); err != nil {
+		return 0, err
+	}
+
+	return row, f.SetPanes(sheetDays, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+}
+
+// summaryInputs returns the input rows at the top of the summary sheet: the report period and the hourly rate.
+func summaryInputs(styles spreadsheetStyles, report *billing.Report) []summaryInput {
+	return []summaryInput{
+		{"Calendar", reportCalendarName(), 0},
+		{"Start", spreadsheetDate(report.Query.Start), styles.date},
+		{"End", spreadsheetDate(report.Query.End), styles.date},
+		{summaryRateLabel, *hourlyRate, styles.amount},
+	}
+}
+
+// summaryRateCell returns an absolute reference to the hourly rate cell of summary sheet input rows.
+func summaryRateCell(inputs []summaryInput) string {
+	row := slices.IndexFunc(inputs, func(in summaryInput) bool { return in.label == summaryRateLabel }) + 1
+
+	return fmt.Sprintf("%s!$B$%d", sheetSummary, row)
+}
+
+// writeSummarySheet writes the summary input rows, surcharge multiplier input cells, SUM formulas over the day rows
+// and a surcharge formula per category. An offline report notes its snapshot time below the formulas.
+func writeSummarySheet(f *excelize.File, styles spreadsheetStyles, report *billing.Report, rows []summaryInput,
+	lastRow int,
+) error {
+	rateCell := summaryRateCell(rows)
+
+	// Keep ranges valid (B2:B2) even when there are no day rows
+	lastRow = max(lastRow, 2)
+
+	for i, r := range rows {
+		if err := setRow(f, sheetSummary, i+1, r.label, r.value); err != nil {
+			return err
+		}
+
+		if r.style != 0 {
+			if err := f.SetCellStyle(sheetSummary, cellName(2, i+1), cellName(2, i+1), r.style); err != nil {
+				return err
+			}
+		}
+	}
+
+	formulas := [][2]string{
+		{"Total hours", fmt.Sprintf("SUM(%s!B2:B%d)", sheetDays, lastRow)},
+		{"Active days", fmt.Sprintf("COUNT(%s!A2:A%d)", sheetDays, lastRow)},
+		{"Total amount", fmt.Sprintf("SUM(%s!D2:D%d)", sheetDays, lastRow)},
+	}
+
+	formulaRow := len(rows) + 1
+	totalAmountRow := formulaRow + len(formulas) - 1
+
+	if err := setFormulaRows(f, formulaRow, formulas); err != nil {
+		return err
+	}
+
+	// Surcharge multiplier input cells, one per category, starting right after the formulas
+	multRow := totalAmountRow + 1
+	m := multipliers()
+	multRows := [][]any{
+		{billing.CategorySaturday.String() + " multiplier", m.Saturday},
+		{billing.CategorySunday.String() + " multiplier", m.Sunday},
+		{billing.CategoryHoliday.String() + " multiplier", m.Holiday},
+		{billing.AfterHoursName + " multiplier", m.AfterHours},
+	}
+
+	for i, r := range multRows {
+		if err := setRow(f, sheetSummary, multRow+i, r...); err != nil {
+			return err
+		}
+	}
+
+	// Hours of a day category column summed over all day rows
+	sumIfCategory := func(c billing.DayCategory, col string) string {
+		return fmt.Sprintf(`SUMIF(%[1]s!$E$2:$E$%[2]d,"%[3]s",%[1]s!$%[4]s$2:$%[4]s$%[2]d)`, sheetDays, lastRow, c, col)
+	}
+
+	// Surcharge is the amount on top of the base amount, already billed at the regular rate; after-hours work only
+	// counts on regular days, so no hour is surcharged twice
+	surchargeRow := multRow + len(multRows)
+	surcharge := func(hours string, multiplierRow int) string {
+		return fmt.Sprintf("%s*%s*(B%d-1)", hours, rateCell, multiplierRow)
+	}
+	surcharges := [][2]string{
+		{billing.CategorySaturday.String() + " surcharge", surcharge(sumIfCategory(billing.CategorySaturday, "B"), multRow)},
+		{billing.CategorySunday.String() + " surcharge", surcharge(sumIfCategory(billing.CategorySunday, "B"), multRow+1)},
+		{billing.CategoryHoliday.String() + " surcharge", surcharge(sumIfCategory(billing.CategoryHoliday, "B"), multRow+2)},
+		{billing.AfterHoursName + " surcharge", surcharge(sumIfCategory(billing.CategoryRegular, "F"), multRow+3)},
+		{"Total billed amount", fmt.Sprintf("B%d+SUM(B%d:B%d)", totalAmountRow, surchargeRow, surchargeRow+len(multRows)-1)},
+	}
+
+	if err := setFormulaRows(f, surchargeRow, surcharges); err != nil {
+		return err
+	}
+
+	lastSummaryRow := surchargeRow + len(surcharges) - 1
+
+	if err := f.SetCellStyle(sheetSummary, "A1", cellName(1, lastSummaryRow), styles.header); err != nil {
+		return err
+	}
+
+	for row, style := range map[int]int{formulaRow: styles.hours, totalAmountRow: styles.amount} {
+		if err := f.SetCellStyle(sheetSummary, cellName(2, row), cellName(2, row), style); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SetCellStyle(sheetSummary, cellName(2, surchargeRow), cellName(2, lastSummaryRow), styles.amount); err != nil {
+		return err
+	}
+
+	// Leave a blank row between formulas and the snapshot note, so no formula cell moves
+	if offlineFinal {
+		note := "Note: offline snapshot of calendar events synced at " + snapshotTime(report)
+		if err := f.SetCellValue(sheetSummary, cellName(1, lastSummaryRow+2), note); err != nil {
+			return err
+		}
+	}
+
+	return f.SetColWidth(sheetSummary, "A", "B", 24)
+}
+
+// setFormulaRows writes label and formula pairs into consecutive summary sheet rows, starting from a given row.
+func setFormulaRows(f *excelize.File, startRow int, formulas [][2]string) error {
+	for i, r := range formulas {
+		row := startRow + i
+
+		if err := f.SetCellValue(sheetSummary, cellName(1, row), r[0]); err != nil {
+			return err
+		}
+
+		if err := f.SetCellFormula(sheetSummary, cellName(2, row), r[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeHolidaysSheet writes public holidays with hours worked on each of them, summed from the day rows, followed by
+// holiday lookup failure notes.
+func writeHolidaysSheet(f *excelize.File, styles spreadsheetStyles, holidayMap map[string]billing.Holiday,
+	holidayErr error, lastRow int,
+) error {
+	if err := writeHeader(f, sheetHolidays, styles.header, "Date", "Holiday", "Hours worked"); err != nil {
+		return err
+	}
+
+	lastRow = max(lastRow, 2)
+
+	holidayKeys := make([]string, 0, len(holidayMap))
+	for k := range holidayMap {
+		holidayKeys = append(holidayKeys, k)
+	}
+
+	slices.Sort(holidayKeys)
+
+	row := 1
+
+	for _, k := range holidayKeys {
+		day, err := time.Parse(billing.DateLayout, k)
+		if err != nil {
+			return fmt.Errorf("invalid holiday date %q: %w", k, err)
+		}
+
+		row++
+
+		if err := setRow(f, sheetHolidays, row, day, holidayMap[k].Description); err != nil {
+			return err
+		}
+
+		formula := fmt.Sprintf("SUMIF(%[1]s!$A$2:$A$%[2]d,A%[3]d,%[1]s!$B$2:$B$%[2]d)", sheetDays, lastRow, row)
+		if err := f.SetCellFormula(sheetHolidays, cellName(3, row), formula); err != nil {
+			return err
+		}
+	}
+
+	if err := setColumnStyles(f, sheetHolidays, row, styles.date, 0, styles.hours); err != nil {
+		return err
+	}
+
+	// Leave a blank row between holidays and notes
+	row++
+
+	for _, line := range holidayErrorLines(holidayErr) {
+		row++
+
+		if err := f.SetCellValue(sheetHolidays, cellName(1, row), "Note: holiday check unavailable: "+line); err != nil {
+			return err
+		}
+	}
+
+	return f.SetColWidth(sheetHolidays, "B", "B", 40)
+}
+
+// writeHeader writes a bold header row into the first sheet row.
+func writeHeader(f *excelize.File, sheet string, style int, titles ...string) error {
+	values := make([]any, len(titles))
+	for i, t := range titles {
+		values[i] = t
+	}
+
+	if err := setRow(f, sheet, 1, values...); err != nil {
+		return err
+	}
+
+	return f.SetCellStyle(sheet, "A1", cellName(len(titles), 1), style)
+}
+
+// setRow writes values into consecutive cells of a sheet row, starting from column A.
+func setRow(f *excelize.File, sheet string, row int, values ...any) error {
+	for i, v := range values {
+		if err := f.SetCellValue(sheet, cellName(i+1, row), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setColumnStyles applies per-column styles to data rows 2..lastRow; a zero style leaves the column unstyled.
+func setColumnStyles(f *excelize.File, sheet string, lastRow int, styles ...int) error {
+	if lastRow < 2 {
+		return nil
+	}
+
+	for i, style := range styles {
+		if style == 0 {
+			continue
+		}
+
+		if err := f.SetCellStyle(sheet, cellName(i+1, 2), cellName(i+1, lastRow), style); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spreadsheetDate drops time of day and location, since spreadsheet date serials are zone-less calendar days.
+func spreadsheetDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// cellName converts 1-based column and row numbers into an A1-style cell reference.
+func cellName(col, row int) string {
+	name, _ := excelize.CoordinatesToCellName(col, row)
+
+	return name
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/xuri/excelize/v2"
+)
+
+// setSpreadsheetGlobals sets report globals used by writeSpreadsheet and restores them on cleanup.
+func setSpreadsheetGlobals(t *testing.T, rate float64) {
+	t.Helper()
+
+	origCalendarName := calendarName
+	origStart := startDateFinal
+	origEnd := endDateFinal
+
+	t.Cleanup(func() {
+		calendarName = origCalendarName
+		startDateFinal = origStart
+		endDateFinal = origEnd
+	})
+
+	calName := "TestCal"
+	calendarName = &calName
+
+	setSurchargeGlobals(t, rate, 1.5, 2, 2, 1.25)
+
+	startDateFinal = time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	endDateFinal = time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+}
+
+func TestWriteSpreadsheet_FormulasAndTotals(t *testing.T) {
+	setSpreadsheetGlobals(t, 50)
+
+	report := billing.NewReport(billing.Query{Start: startDateFinal, End: endDateFinal})
+	report.Days["2024-01-16"] = billing.NewDay("Second day", 3, 2)
+	report.Days["2024-01-15"] = billing.NewDay("First day", 8, 0)
+	report.Days["2024-01-13"] = billing.NewDay("Saturday", 4, 0)
+	report.Holidays["2024-01-15"] = billing.Holiday{Description: "Public Holiday"}
+	report.Holidays["2024-01-25"] = billing.Holiday{Description: "Another Holiday"}
+
+	path := filepath.Join(t.TempDir(), "report.xlsx")
+
+	if err := writeSpreadsheet(path, report, nil); err != nil {
+		t.Fatalf("writeSpreadsheet: %v", err)
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	// Day rows must be sorted by date with descriptions in column C
+	if got, _ := f.GetCellValue(sheetDays, "C3"); got != "First day" {
+		t.Errorf("Days!C3: got %q, want %q", got, "First day")
+	}
+
+	if got, _ := f.GetCellValue(sheetDays, "A3"); got != "2024-01-15" {
+		t.Errorf("Days!A3: got %q, want formatted date 2024-01-15", got)
+	}
+
+	if got, _ := f.GetCellValue(sheetDays, "E2"); got != "Saturday" {
+		t.Errorf("Days!E2: got %q, want Saturday", got)
+	}
+
+	if got, _ := f.GetCellValue(sheetDays, "E3"); got != "Holiday" {
+		t.Errorf("Days!E3: got %q, want Holiday", got)
+	}
+
+	// Totals must be formulas, not hard-coded values
+	if got, _ := f.GetCellFormula(sheetSummary, "B5"); got != "SUM(Days!B2:B4)" {
+		t.Errorf("Summary!B5 formula: got %q, want SUM(Days!B2:B4)", got)
+	}
+
+	tests := []struct {
+		sheet, cell, want string
+	}{
+		{sheetSummary, "B5", "15"},
+		{sheetSummary, "B6", "3"},
+		{sheetSummary, "B7", "750"},
+		{sheetSummary, "B12", "100"},  // Saturday: 4h*50*0.5
+		{sheetSummary, "B13", "0"},    // Sunday: no work
+		{sheetSummary, "B14", "400"},  // Holiday: 8h*50*1
+		{sheetSummary, "B15", "25"},   // After hours: 2h*50*0.25
+		{sheetSummary, "B16", "1275"}, // 750 + 525
+		{sheetDays, "D4", "150"},
+		{sheetHolidays, "C2", "8"},
+		{sheetHolidays, "C3", "0"},
+	}
+
+	for _, tc := range tests {
+		got, err := f.CalcCellValue(tc.sheet, tc.cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			t.Errorf("CalcCellValue(%s!%s): %v", tc.sheet, tc.cell, err)
+
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s!%s: got %q, want %q", tc.sheet, tc.cell, got, tc.want)
+		}
+	}
+
+	// Changing the rate cell must drive the amount column
+	if err := f.SetCellValue(sheetSummary, "B4", 100); err != nil {
+		t.Fatalf("SetCellValue: %v", err)
+	}
+
+	if got, _ := f.CalcCellValue(sheetSummary, "B7", excelize.Options{RawCellValue: true}); got != "1500" {
+		t.Errorf("Summary!B7 after rate change: got %q, want 1500", got)
+	}
+}
+
+func TestWriteSpreadsheet_Empty(t *testing.T) {
+	setSpreadsheetGlobals(t, 0)
+
+	path := filepath.Join(t.TempDir(), "empty.xlsx")
+
+	if err := writeSpreadsheet(path, billing.NewReport(billing.Query{Start: startDateFinal, End: endDateFinal}), nil); err != nil {
+		t.Fatalf("writeSpreadsheet: %v", err)
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	if got, _ := f.CalcCellValue(sheetSummary, "B5", excelize.Options{RawCellValue: true}); got != "0" {
+		t.Errorf("Summary!B5: got %q, want 0", got)
+	}
+}
@@ -5,6 +5,7 @@
 package geoip
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -13,14 +14,22 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/dkorunic/IM-billing-v2/cache"
 )
 
 const (
 	// URL is a default GeoIP URL with JSON response.
 	URL = "https://ifconfig.co/json"
 
+	// ProviderIfconfig is a provider name of ifconfig.co.
+	ProviderIfconfig = "ifconfig"
+
 	// DefaultTimeout is a default Ifconfig/GeoIP request timeout.
 	DefaultTimeout = 10 * time.Second
+
+	// DefaultCacheTTL is a default GeoIP response cache TTL; public IP may change, so keep it short.
+	DefaultCacheTTL = 1 * time.Hour
 )
 
 var ErrNilBody = errors.New("client body is nil")
@@ -35,26 +44,95 @@ type Response struct {
 	Hostname   string      `json:"hostname"`
 }
 
-// Client is an Ifconfig client that performs simple geolocation.
+// Client is a GeoIP HTTP JSON API client that performs simple geolocation, by default through ifconfig.co.
 type Client struct {
 	httpClient *http.Client
 	URL        *url.URL
+	Cache      *cache.Cache // optional on-disk response cache, nil disables caching
+	decode     decodeFunc   // provider-specific JSON decoder, nil for ifconfig.co
+	name       string       // provider name, empty for ifconfig.co
+}
+
+// decodeFunc decodes a provider-specific JSON response into a Response.
+type decodeFunc func(r io.Reader, geoip *Response) error
+
+// Option configures a GeoIP provider.
+type Option func(*options)
+
+// options holds settings shared by all GeoIP providers.
+type options struct {
+	httpClient *http.Client
+}
+
+// WithHTTPClient sets an HTTP client used for GeoIP requests, e.g. one with a proxy or a private CA bundle.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		if httpClient != nil {
+			o.httpClient = httpClient
+		}
+	}
+}
+
+// applyOptions applies provider options over defaults.
+func applyOptions(opts []Option) options {
+	o := options{httpClient: &http.Client{}}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
 }
 
 // NewClient prepares HTTP client structure for Ifconfig API request.
-func NewClient() (*Client, error) {
+func NewClient(opts ...Option) (*Client, error) {
 	ifconfigURL, err := url.Parse(URL)
 	if err != nil {
 		return nil, err
 	}
 
-	c := &Client{httpClient: &http.Client{}, URL: ifconfigURL}
+	c := &Client{httpClient: applyOptions(opts).httpClient, URL: ifconfigURL}
 
 	return c, nil
 }
 
-// GetResponse fetches a HTTP response with JSON body from ifconfig.co site and parses it.
+// Name returns a provider name of the client.
+func (c *Client) Name() string {
+	if c.name == "" {
+		return ProviderIfconfig
+	}
+
+	return c.name
+}
+
+// Lookup implements Provider by fetching and parsing the GeoIP response.
+func (c *Client) Lookup(ctx context.Context) (Response, error) {
+	return c.GetResponse(ctx)
+}
+
+// decodeResponse decodes a JSON response with the provider-specific decoder.
+func (c *Client) decodeResponse(r io.Reader, geoip *Response) error {
+	if c.decode == nil {
+		return json.NewDecoder(r).Decode(geoip)
+	}
+
+	return c.decode(r, geoip)
+}
+
+// GetResponse fetches a HTTP response with JSON body from the GeoIP provider (ifconfig.co by default) and parses it,
+// going through the response cache when configured.
 func (c *Client) GetResponse(ctx context.Context) (geoip Response, err error) {
+	if c.Cache != nil {
+		body, err := c.Cache.Get(ctx, c.httpClient, c.URL.String())
+		if err != nil {
+			return Response{}, err
+		}
+
+		err = c.decodeResponse(bytes.NewReader(body), &geoip)
+
+		return geoip, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL.String(), nil)
 	if err != nil {
 		return Response{}, err
@@ -91,7 +169,7 @@ func (c *Client) GetResponse(ctx context.Context) (geoip Response, err error) {
 	}
 
 	// Stream-decode JSON directly from response body
-	err = json.NewDecoder(resp.Body).Decode(&geoip)
+	err = c.decodeResponse(resp.Body, &geoip)
 
 	return geoip, err
 }
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/dkorunic/IM-billing-v2/cache"
 	"github.com/dkorunic/IM-billing-v2/geoip"
 )
 
@@ -141,3 +142,38 @@ func TestGetResponse_CancelledContextReturnsContextError(t *testing.T) {
 		t.Errorf("expected context.Canceled, got: %v", err)
 	}
 }
+
+func TestGetResponse_CachedFallback(t *testing.T) {
+	fail := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ip":"1.2.3.4","country_iso":"HR"}`))
+	}))
+	defer srv.Close()
+
+	client, _ := geoip.NewClient()
+	client.URL, _ = url.Parse(srv.URL)
+	client.Cache = cache.New(t.TempDir(), 0)
+
+	if _, err := client.GetResponse(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Rate-limited refetch must fall back to the stale cached response
+	fail = true
+
+	resp, err := client.GetResponse(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.CountryISO != "HR" {
+		t.Errorf("CountryISO: got %q, want %q", resp.CountryISO, "HR")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// IPURL is a default public IP echo URL with plain text response, used for local database lookups.
+const IPURL = "https://api.ipify.org"
+
+var (
+	ErrNoDatabase = errors.New("MaxMind database path not configured")
+	ErrIPNotFound = errors.New("IP address not found in MaxMind database")
+	ErrInvalidIP  = errors.New("invalid public IP address")
+)
+
+// maxMindRecord is a subset of GeoLite2 / GeoIP2 Country and City database record.
+type maxMindRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// MaxMindProvider geolocates current public IP with a local MaxMind GeoLite2 / GeoIP2 .mmdb database. Only the
+// public IP itself is fetched remotely, from a plain text IP echo service.
+type MaxMindProvider struct {
+	httpClient *http.Client
+	IPURL      *url.URL
+	Path       string
+}
+
+// NewMaxMindProvider prepares a MaxMind database provider for a .mmdb database path.
+func NewMaxMindProvider(path string, opts ...Option) (*MaxMindProvider, error) {
+	if path == "" {
+		return nil, ErrNoDatabase
+	}
+
+	ipURL, err := url.Parse(IPURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MaxMindProvider{httpClient: applyOptions(opts).httpClient, IPURL: ipURL, Path: path}
+
+	return p, nil
+}
+
+// Name returns a provider name.
+func (p *MaxMindProvider) Name() string {
+	return ProviderMaxMind
+}
+
+// Lookup implements Provider by looking up current public IP in the local database.
+func (p *MaxMindProvider) Lookup(ctx context.Context) (Response, error) {
+	ip, err := p.publicIP(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return p.LookupIP(ip)
+}
+
+// LookupIP looks up an IP address in the local database.
+func (p *MaxMindProvider) LookupIP(ip netip.Addr) (Response, error) {
+	db, err := maxminddb.Open(p.Path)
+	if err != nil {
+		return Response{}, err
+	}
+
+	defer func() { _ = db.Close() }()
+
+	result := db.Lookup(ip)
+	if !result.Found() {
+		return Response{}, fmt.Errorf("%w: %v", ErrIPNotFound, ip)
+	}
+
+	var rec maxMindRecord
+
+	if err := result.Decode(&rec); err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		IP:         ip.String(),
+		Country:    rec.Country.Names["en"],
+		CountryISO: rec.Country.ISOCode,
+		City:       rec.City.Names["en"],
+	}, nil
+}
+
+// publicIP fetches current public IP from a plain text IP echo service.
+func (p *MaxMindProvider) publicIP(ctx context.Context) (ip netip.Addr, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.IPURL.String(), nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	// Do the actual HTTP/HTTPS request
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return netip.Addr{}, ctx.Err()
+		default:
+			return netip.Addr{}, err
+		}
+	}
+
+	if resp == nil || resp.Body == nil {
+		return netip.Addr{}, fmt.Errorf("%w", ErrNilBody)
+	}
+
+	// Defer body close() with error propagation
+	defer func() {
+		cerr := resp.Body.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	ip, err = netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %w", ErrInvalidIP, err)
+	}
+
+	return ip, nil
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+const (
+	// IPInfoURL is an ipinfo.io GeoIP URL with JSON response.
+	IPInfoURL = "https://ipinfo.io/json"
+
+	// IPAPIURL is an ip-api.com GeoIP URL with JSON response; the free tier is HTTP only.
+	IPAPIURL = "http://ip-api.com/json/"
+
+	// ProviderIPInfo is a provider name of ipinfo.io.
+	ProviderIPInfo = "ipinfo"
+
+	// ProviderIPAPI is a provider name of ip-api.com.
+	ProviderIPAPI = "ip-api"
+
+	// ProviderMaxMind is a provider name of a local MaxMind GeoLite2 / GeoIP2 database.
+	ProviderMaxMind = "maxmind"
+)
+
+var (
+	ErrNoCountry       = errors.New("no country ISO code in response")
+	ErrNoProviders     = errors.New("no GeoIP providers configured")
+	ErrUnknownProvider = errors.New("unknown GeoIP provider")
+	ErrIPAPIStatus     = errors.New("ip-api lookup failed")
+)
+
+// DefaultProviders is a default GeoIP provider order.
+var DefaultProviders = []string{ProviderIfconfig, ProviderIPInfo, ProviderIPAPI}
+
+// Provider is a GeoIP provider resolving current public IP to a location.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context) (Response, error)
+}
+
+// ipInfoResponse is a structure for parsed ipinfo.io JSON response.
+type ipInfoResponse struct {
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+	City     string `json:"city"`
+	Country  string `json:"country"` // ISO 3166-1 alpha-2 code
+}
+
+// ipAPIResponse is a structure for parsed ip-api.com JSON response.
+type ipAPIResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Query       string `json:"query"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	City        string `json:"city"`
+}
+
+// NewIPInfoClient prepares HTTP client structure for ipinfo.io API request.
+func NewIPInfoClient(opts ...Option) (*Client, error) {
+	return newProviderClient(ProviderIPInfo, IPInfoURL, decodeIPInfo, opts)
+}
+
+// NewIPAPIClient prepares HTTP client structure for ip-api.com API request.
+func NewIPAPIClient(opts ...Option) (*Client, error) {
+	return newProviderClient(ProviderIPAPI, IPAPIURL, decodeIPAPI, opts)
+}
+
+// newProviderClient prepares HTTP client structure for a named JSON API provider.
+func newProviderClient(name, rawURL string, decode decodeFunc, opts []Option) (*Client, error) {
+	providerURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{httpClient: applyOptions(opts).httpClient, URL: providerURL, decode: decode, name: name}
+
+	return c, nil
+}
+
+// NewProvider creates a GeoIP provider by name; mmdbPath is only used by the MaxMind provider.
+func NewProvider(name, mmdbPath string, opts ...Option) (Provider, error) {
+	switch name {
+	case ProviderIfconfig:
+		return NewClient(opts...)
+	case ProviderIPInfo:
+		return NewIPInfoClient(opts...)
+	case ProviderIPAPI:
+		return NewIPAPIClient(opts...)
+	case ProviderMaxMind:
+		return NewMaxMindProvider(mmdbPath, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+}
+
+// Lookup tries GeoIP providers in order, each bounded by DefaultTimeout, and returns the first response with a
+// country ISO code. If all providers fail, errors of all providers are returned joined.
+func Lookup(ctx context.Context, providers []Provider) (Response, error) {
+	if len(providers) == 0 {
+		return Response{}, ErrNoProviders
+	}
+
+	var errs []error
+
+	for _, p := range providers {
+		geoip, err := lookupWithTimeout(ctx, p)
+
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		case geoip.CountryISO == "":
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), ErrNoCountry))
+		default:
+			return geoip, nil
+		}
+
+		// Do not try remaining providers once the parent context is done
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return Response{}, errors.Join(errs...)
+}
+
+// lookupWithTimeout does a single provider lookup bounded by DefaultTimeout.
+func lookupWithTimeout(ctx context.Context, p Provider) (Response, error) {
+	ctxLookup, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	return p.Lookup(ctxLookup)
+}
+
+// decodeIPInfo decodes ipinfo.io JSON response.
+func decodeIPInfo(r io.Reader, geoip *Response) error {
+	var resp ipInfoResponse
+
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return err
+	}
+
+	*geoip = Response{IP: resp.IP, CountryISO: resp.Country, City: resp.City, Hostname: resp.Hostname}
+
+	return nil
+}
+
+// decodeIPAPI decodes ip-api.com JSON response, which reports failures in-band with HTTP 200.
+func decodeIPAPI(r io.Reader, geoip *Response) error {
+	var resp ipAPIResponse
+
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return fmt.Errorf("%w: %s", ErrIPAPIStatus, resp.Message)
+	}
+
+	*geoip = Response{IP: resp.Query, Country: resp.Country, CountryISO: resp.CountryCode, City: resp.City}
+
+	return nil
+}
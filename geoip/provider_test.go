@@ -0,0 +1,197 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package geoip_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dkorunic/IM-billing-v2/geoip"
+)
+
+// newJSONServer serves a fixed JSON body with a given HTTP status.
+func newJSONServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestIPInfoClient_Lookup(t *testing.T) {
+	srv := newJSONServer(t, http.StatusOK, `{"ip":"1.2.3.4","city":"Zagreb","country":"HR"}`)
+
+	client, err := geoip.NewIPInfoClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client.URL, _ = url.Parse(srv.URL)
+
+	resp, err := client.Lookup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.CountryISO != "HR" || resp.City != "Zagreb" || resp.IP != "1.2.3.4" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if client.Name() != geoip.ProviderIPInfo {
+		t.Errorf("Name: got %q, want %q", client.Name(), geoip.ProviderIPInfo)
+	}
+}
+
+func TestIPAPIClient_Lookup(t *testing.T) {
+	srv := newJSONServer(t, http.StatusOK,
+		`{"status":"success","query":"1.2.3.4","country":"Croatia","countryCode":"HR","city":"Zagreb"}`)
+
+	client, _ := geoip.NewIPAPIClient()
+	client.URL, _ = url.Parse(srv.URL)
+
+	resp, err := client.Lookup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.CountryISO != "HR" || resp.Country != "Croatia" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+// ip-api reports failures in-band with HTTP 200.
+func TestIPAPIClient_FailStatus(t *testing.T) {
+	srv := newJSONServer(t, http.StatusOK, `{"status":"fail","message":"reserved range"}`)
+
+	client, _ := geoip.NewIPAPIClient()
+	client.URL, _ = url.Parse(srv.URL)
+
+	_, err := client.Lookup(context.Background())
+	if !errors.Is(err, geoip.ErrIPAPIStatus) {
+		t.Fatalf("expected ErrIPAPIStatus, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "reserved range") {
+		t.Errorf("error %q does not contain ip-api message", err)
+	}
+}
+
+func TestLookup_FallbackChain(t *testing.T) {
+	limited := newJSONServer(t, http.StatusTooManyRequests, `rate limited`)
+	noCountry := newJSONServer(t, http.StatusOK, `{"ip":"1.2.3.4"}`)
+	ok := newJSONServer(t, http.StatusOK, `{"status":"success","countryCode":"HR"}`)
+
+	ifconfig, _ := geoip.NewClient()
+	ifconfig.URL, _ = url.Parse(limited.URL)
+
+	ipinfo, _ := geoip.NewIPInfoClient()
+	ipinfo.URL, _ = url.Parse(noCountry.URL)
+
+	ipapi, _ := geoip.NewIPAPIClient()
+	ipapi.URL, _ = url.Parse(ok.URL)
+
+	resp, err := geoip.Lookup(context.Background(), []geoip.Provider{ifconfig, ipinfo, ipapi})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.CountryISO != "HR" {
+		t.Errorf("CountryISO: got %q, want HR", resp.CountryISO)
+	}
+}
+
+func TestLookup_AllProvidersFail(t *testing.T) {
+	limited := newJSONServer(t, http.StatusTooManyRequests, `rate limited`)
+	noCountry := newJSONServer(t, http.StatusOK, `{"ip":"1.2.3.4"}`)
+
+	ifconfig, _ := geoip.NewClient()
+	ifconfig.URL, _ = url.Parse(limited.URL)
+
+	ipinfo, _ := geoip.NewIPInfoClient()
+	ipinfo.URL, _ = url.Parse(noCountry.URL)
+
+	_, err := geoip.Lookup(context.Background(), []geoip.Provider{ifconfig, ipinfo})
+	if err == nil {
+		t.Fatal("expected error when all providers fail, got nil")
+	}
+
+	if !errors.Is(err, geoip.ErrNoCountry) {
+		t.Errorf("expected joined ErrNoCountry, got %v", err)
+	}
+
+	for _, want := range []string{"ifconfig: HTTP 429", "ipinfo: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	if _, err := geoip.Lookup(context.Background(), nil); !errors.Is(err, geoip.ErrNoProviders) {
+		t.Errorf("expected ErrNoProviders, got %v", err)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	for _, name := range geoip.DefaultProviders {
+		p, err := geoip.NewProvider(name, "")
+		if err != nil {
+			t.Fatalf("NewProvider(%q): %v", name, err)
+		}
+
+		if p.Name() != name {
+			t.Errorf("Name: got %q, want %q", p.Name(), name)
+		}
+	}
+
+	if _, err := geoip.NewProvider("nope", ""); !errors.Is(err, geoip.ErrUnknownProvider) {
+		t.Errorf("expected ErrUnknownProvider, got %v", err)
+	}
+
+	if _, err := geoip.NewProvider(geoip.ProviderMaxMind, ""); !errors.Is(err, geoip.ErrNoDatabase) {
+		t.Errorf("expected ErrNoDatabase, got %v", err)
+	}
+}
+
+func TestMaxMindProvider_MissingDatabase(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("1.2.3.4\n"))
+	}))
+	defer srv.Close()
+
+	p, err := geoip.NewMaxMindProvider(filepath.Join(t.TempDir(), "missing.mmdb"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.IPURL, _ = url.Parse(srv.URL)
+
+	if _, err := p.Lookup(context.Background()); err == nil {
+		t.Fatal("expected error for missing database, got nil")
+	}
+}
+
+func TestMaxMindProvider_InvalidPublicIP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("<html>not an IP</html>"))
+	}))
+	defer srv.Close()
+
+	p, _ := geoip.NewMaxMindProvider(filepath.Join(t.TempDir(), "missing.mmdb"))
+	p.IPURL, _ = url.Parse(srv.URL)
+
+	if _, err := p.Lookup(context.Background()); !errors.Is(err, geoip.ErrInvalidIP) {
+		t.Errorf("expected ErrInvalidIP, got %v", err)
+	}
+}
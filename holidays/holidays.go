@@ -0,0 +1,122 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package holidays
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedCountry = errors.New("no built-in holiday rules for country")
+
+// Holiday is an individual public holiday generated from rules.
+type Holiday struct {
+	Date time.Time
+	Name string
+}
+
+// Rule is a holiday rule producing at most one holiday date per year, valid only within an optional year range.
+type Rule struct {
+	date     func(year int) time.Time
+	Name     string
+	FromYear int // first year the rule is valid, 0 for no lower bound
+	ToYear   int // last year the rule is valid, 0 for no upper bound
+}
+
+// Fixed creates a rule for a holiday on the same month and day every year.
+func Fixed(name string, month time.Month, day int) Rule {
+	return Rule{
+		Name: name,
+		date: func(year int) time.Time {
+			return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		},
+	}
+}
+
+// EasterOffset creates a rule for a holiday relative to Western (Gregorian) Easter Sunday, e.g. 1 for Easter
+// Monday or 60 for Corpus Christi.
+func EasterOffset(name string, days int) Rule {
+	return Rule{
+		Name: name,
+		date: func(year int) time.Time {
+			return Easter(year).AddDate(0, 0, days)
+		},
+	}
+}
+
+// Between returns a copy of the rule limited to years from..to inclusive; zero means an open bound.
+func (r Rule) Between(from, to int) Rule {
+	r.FromYear = from
+	r.ToYear = to
+
+	return r
+}
+
+// validIn reports whether the rule applies to a given year.
+func (r Rule) validIn(year int) bool {
+	return (r.FromYear == 0 || year >= r.FromYear) && (r.ToYear == 0 || year <= r.ToYear)
+}
+
+// countryRules maps ISO 3166-1 alpha-2 country codes to built-in holiday rules.
+var countryRules = map[string][]Rule{
+	"HR": croatia,
+}
+
+// Countries returns sorted ISO 3166-1 country codes with built-in holiday rules.
+func Countries() []string {
+	return slices.Sorted(maps.Keys(countryRules))
+}
+
+// Generate returns holidays for a country ISO code in years from..to inclusive, sorted by date.
+func Generate(countryISO string, from, to int) ([]Holiday, error) {
+	rules, ok := countryRules[strings.ToUpper(countryISO)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (supported: %s)", ErrUnsupportedCountry, countryISO,
+			strings.Join(Countries(), ", "))
+	}
+
+	var hs []Holiday
+
+	for year := from; year <= to; year++ {
+		for _, r := range rules {
+			if !r.validIn(year) {
+				continue
+			}
+
+			hs = append(hs, Holiday{Date: r.date(year), Name: r.Name})
+		}
+	}
+
+	slices.SortStableFunc(hs, func(a, b Holiday) int {
+		return a.Date.Compare(b.Date)
+	})
+
+	return hs, nil
+}
+
+// Easter returns Western (Gregorian) Easter Sunday for a year using the anonymous Gregorian algorithm
+// (Meeus/Jones/Butcher).
+func Easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
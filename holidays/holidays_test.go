@@ -0,0 +1,132 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package holidays_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/holidays"
+)
+
+func TestEaster(t *testing.T) {
+	tests := []struct {
+		year  int
+		month time.Month
+		day   int
+	}{
+		{2019, time.April, 21},
+		{2020, time.April, 12},
+		{2024, time.March, 31},
+		{2025, time.April, 20},
+		{2038, time.April, 25},
+	}
+
+	for _, tc := range tests {
+		got := holidays.Easter(tc.year)
+		want := time.Date(tc.year, tc.month, tc.day, 0, 0, 0, 0, time.UTC)
+
+		if !got.Equal(want) {
+			t.Errorf("Easter(%d): got %v, want %v", tc.year, got.Format(time.DateOnly), want.Format(time.DateOnly))
+		}
+	}
+}
+
+// findHoliday returns a holiday name for a date, or an empty string.
+func findHoliday(hs []holidays.Holiday, date string) string {
+	for _, h := range hs {
+		if h.Date.Format(time.DateOnly) == date {
+			return h.Name
+		}
+	}
+
+	return ""
+}
+
+func TestGenerate_Croatia2024(t *testing.T) {
+	hs, err := holidays.Generate("hr", 2024, 2024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(hs) != 14 {
+		t.Errorf("expected 14 holidays, got %d", len(hs))
+	}
+
+	tests := map[string]string{
+		"2024-01-01": "New Year's Day",
+		"2024-04-01": "Easter Monday",
+		"2024-11-18": "Remembrance Day",
+		"2024-12-26": "St. Stephen's Day",
+	}
+
+	for date, want := range tests {
+		if got := findHoliday(hs, date); got != want {
+			t.Errorf("%s: got %q, want %q", date, got, want)
+		}
+	}
+
+	// Corpus Christi (Easter + 60 days) falls on Statehood Day in 2024; both must be kept
+	sameDay := 0
+
+	for _, h := range hs {
+		if h.Date.Format(time.DateOnly) == "2024-05-30" {
+			sameDay++
+		}
+	}
+
+	if sameDay != 2 {
+		t.Errorf("2024-05-30: expected Statehood Day and Corpus Christi, got %d holidays", sameDay)
+	}
+
+	for i := 1; i < len(hs); i++ {
+		if hs[i].Date.Before(hs[i-1].Date) {
+			t.Fatalf("holidays not sorted: %v before %v", hs[i].Date, hs[i-1].Date)
+		}
+	}
+}
+
+// Year-specific rules: the 2020 amendment moved Statehood Day and dropped Independence Day.
+func TestGenerate_CroatiaYearSpecific(t *testing.T) {
+	hs, err := holidays.Generate("HR", 2019, 2020)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"2019-06-25": "Statehood Day",
+		"2019-10-08": "Independence Day",
+		"2019-11-18": "",
+		"2020-05-30": "Statehood Day",
+		"2020-06-25": "",
+		"2020-10-08": "",
+		"2020-11-18": "Remembrance Day",
+	}
+
+	for date, want := range tests {
+		if got := findHoliday(hs, date); got != want {
+			t.Errorf("%s: got %q, want %q", date, got, want)
+		}
+	}
+}
+
+func TestGenerate_CroatiaCorpusChristi(t *testing.T) {
+	hs, err := holidays.Generate("HR", 2025, 2025)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := findHoliday(hs, "2025-06-19"); got != "Corpus Christi" {
+		t.Errorf("2025-06-19: got %q, want %q", got, "Corpus Christi")
+	}
+}
+
+func TestGenerate_UnsupportedCountry(t *testing.T) {
+	_, err := holidays.Generate("XX", 2024, 2024)
+	if !errors.Is(err, holidays.ErrUnsupportedCountry) {
+		t.Errorf("expected ErrUnsupportedCountry, got %v", err)
+	}
+}
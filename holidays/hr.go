@@ -0,0 +1,28 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package holidays
+
+import "time"
+
+// croatia holds Croatian public holidays per the Holidays, Memorial Days and Non-Working Days Act, including the
+// 2020 amendment that moved Statehood Day and replaced Independence Day with Remembrance Day.
+var croatia = []Rule{
+	Fixed("New Year's Day", time.January, 1),
+	Fixed("Epiphany", time.January, 6),
+	EasterOffset("Easter Sunday", 0),
+	EasterOffset("Easter Monday", 1),
+	Fixed("Labour Day", time.May, 1),
+	Fixed("Statehood Day", time.May, 30).Between(2020, 0),
+	EasterOffset("Corpus Christi", 60),
+	Fixed("Anti-Fascist Struggle Day", time.June, 22),
+	Fixed("Statehood Day", time.June, 25).Between(0, 2019),
+	Fixed("Victory and Homeland Thanksgiving Day", time.August, 5),
+	Fixed("Assumption Day", time.August, 15),
+	Fixed("Independence Day", time.October, 8).Between(0, 2019),
+	Fixed("All Saints' Day", time.November, 1),
+	Fixed("Remembrance Day", time.November, 18).Between(2020, 0),
+	Fixed("Christmas Day", time.December, 25),
+	Fixed("St. Stephen's Day", time.December, 26),
+}
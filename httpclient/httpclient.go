@@ -0,0 +1,141 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	// DefaultUserAgent is a default User-Agent header value for all outgoing requests.
+	DefaultUserAgent = "IM-billing-v2"
+
+	// TLS12 is a TLS 1.2 minimum version name.
+	TLS12 = "1.2"
+
+	// TLS13 is a TLS 1.3 minimum version name.
+	TLS13 = "1.3"
+)
+
+var (
+	ErrProxyURL   = errors.New("invalid proxy URL")
+	ErrCAFile     = errors.New("unable to load CA bundle")
+	ErrTLSVersion = errors.New("unsupported minimum TLS version")
+	ErrOffline    = errors.New("network access disabled in offline mode")
+)
+
+// Options configures the shared HTTP transport.
+type Options struct {
+	ProxyURL      string // explicit proxy URL, empty uses HTTP_PROXY / HTTPS_PROXY / NO_PROXY environment
+	CAFile        string // PEM CA bundle trusted in addition to system roots
+	UserAgent     string // User-Agent header value, empty uses DefaultUserAgent
+	TLSMinVersion string // minimum TLS version (1.2 or 1.3), empty uses TLS 1.2
+}
+
+// userAgentTransport sets a User-Agent header on every request, replacing any header set by API clients such as the
+// Google API client, which always sets its own.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper must not modify the original request
+	r := req.Clone(req.Context())
+	r.Header.Set("User-Agent", t.userAgent)
+
+	return t.base.RoundTrip(r)
+}
+
+// New creates an HTTP client with a configured proxy, CA bundle, User-Agent and minimum TLS version, shared by GeoIP,
+// holiday ICS and Google API requests.
+func New(opts Options) (*http.Client, error) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		transport = &http.Transport{}
+	}
+
+	transport = transport.Clone()
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrProxyURL, opts.ProxyURL)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch opts.TLSMinVersion {
+	case "", TLS12:
+	case TLS13:
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrTLSVersion, opts.TLSMinVersion)
+	}
+
+	if opts.CAFile != "" {
+		pool, err := loadCAFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	return &http.Client{Transport: &userAgentTransport{base: transport, userAgent: userAgent}}, nil
+}
+
+// offlineTransport fails every request without network access.
+type offlineTransport struct{}
+
+// RoundTrip implements http.RoundTripper.
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%w: %s %s", ErrOffline, req.Method, req.URL.Redacted())
+}
+
+// Offline creates an HTTP client failing every request with ErrOffline, guarding offline mode against any request
+// that slips through.
+func Offline() *http.Client {
+	return &http.Client{Transport: offlineTransport{}}
+}
+
+// loadCAFile returns system root CAs extended with PEM certificates from a CA bundle file.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCAFile, err)
+	}
+
+	// Private CA is trusted in addition to, not instead of, public roots
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: no PEM certificates in %s", ErrCAFile, path)
+	}
+
+	return pool, nil
+}
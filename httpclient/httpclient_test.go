@@ -0,0 +1,192 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package httpclient_test
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dkorunic/IM-billing-v2/httpclient"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func TestNew_UserAgent(t *testing.T) {
+	var gotUA string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct{ ua, want string }{
+		{"", httpclient.DefaultUserAgent},
+		{"billing-bot/1.0", "billing-bot/1.0"},
+	} {
+		client, err := httpclient.New(httpclient.Options{UserAgent: tc.ua})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+
+		_ = resp.Body.Close()
+
+		if gotUA != tc.want {
+			t.Errorf("User-Agent: got %q, want %q", gotUA, tc.want)
+		}
+	}
+}
+
+func TestNew_CalendarUserAgent(t *testing.T) {
+	var gotUA string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	client, err := httpclient.New(httpclient.Options{UserAgent: "billing-bot/1.0"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	calSrv, err := calendar.NewService(t.Context(), option.WithEndpoint(srv.URL), option.WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	if _, err = calSrv.CalendarList.List().Do(); err != nil {
+		t.Fatalf("CalendarList: %v", err)
+	}
+
+	if gotUA != "billing-bot/1.0" {
+		t.Errorf("User-Agent: got %q, want %q", gotUA, "billing-bot/1.0")
+	}
+}
+
+func TestNew_Proxy(t *testing.T) {
+	var gotURL string
+
+	// A plain HTTP proxy receives the absolute target URL in the request line
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	client, err := httpclient.New(httpclient.Options{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	resp, err := client.Get("http://holidays.example.invalid/ics")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if gotURL != "http://holidays.example.invalid/ics" {
+		t.Errorf("proxied URL: got %q, want the absolute target URL", gotURL)
+	}
+}
+
+func TestNew_CAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// Without the private CA the self-signed test certificate must be rejected
+	plain, err := httpclient.New(httpclient.Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if resp, err := plain.Get(srv.URL); err == nil {
+		_ = resp.Body.Close()
+
+		t.Fatal("expected certificate verification error without CA file, got nil")
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	client, err := httpclient.New(httpclient.Options{CAFile: caPath, TLSMinVersion: httpclient.TLS12})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get with CA file: %v", err)
+	}
+
+	_ = resp.Body.Close()
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	invalidPEM := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts httpclient.Options
+		want error
+	}{
+		{"proxy without scheme", httpclient.Options{ProxyURL: "proxy:3128"}, httpclient.ErrProxyURL},
+		{"missing CA file", httpclient.Options{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, httpclient.ErrCAFile},
+		{"CA file without PEM", httpclient.Options{CAFile: invalidPEM}, httpclient.ErrCAFile},
+		{"TLS 1.1", httpclient.Options{TLSMinVersion: "1.1"}, httpclient.ErrTLSVersion},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := httpclient.New(tc.opts); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestOffline(t *testing.T) {
+	var hits int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	resp, err := httpclient.Offline().Get(srv.URL)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+
+	if !errors.Is(err, httpclient.ErrOffline) {
+		t.Fatalf("error: got %v, want ErrOffline", err)
+	}
+
+	if hits != 0 {
+		t.Errorf("offline client reached the server %d times", hits)
+	}
+}
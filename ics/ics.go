@@ -5,14 +5,18 @@
 package ics
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/dkorunic/IM-billing-v2/cache"
 	"github.com/jordic/goics"
 )
 
@@ -22,6 +26,15 @@ const (
 
 	// DefaultTimeout is a default ICS fetch HTTP timeout.
 	DefaultTimeout = 10 * time.Second
+
+	// DefaultCacheTTL is a default ICS response cache TTL; holiday calendars rarely change.
+	DefaultCacheTTL = 7 * 24 * time.Hour
+
+	// SchemeFile is a URL scheme of local ICS files.
+	SchemeFile = "file"
+
+	// maxBodySize is a maximum accepted ICS body size.
+	maxBodySize = 20 << 20
 )
 
 var ErrNilBody = errors.New("client body is nil")
@@ -30,6 +43,7 @@ var ErrNilBody = errors.New("client body is nil")
 type Client struct {
 	httpClient *http.Client
 	URL        *url.URL
+	Cache      *cache.Cache // optional on-disk response cache, nil disables caching
 }
 
 // Event is an individual parsed ICS event for ICS decoder.
@@ -72,8 +86,20 @@ func (e *Events) ConsumeICal(c *goics.Calendar, _ error) error {
 	return nil
 }
 
+// Option configures an ICS client.
+type Option func(*Client)
+
+// WithHTTPClient sets an HTTP client used for ICS requests, e.g. one with a proxy or a private CA bundle.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
 // NewClient creates a HTTP client structure for ICS fetch/parse.
-func NewClient(countryCode string) (*Client, error) {
+func NewClient(countryCode string, opts ...Option) (*Client, error) {
 	IcsURL, err := url.Parse(fmt.Sprintf(URL, url.QueryEscape(countryCode)))
 	if err != nil {
 		return nil, err
@@ -81,11 +107,41 @@ func NewClient(countryCode string) (*Client, error) {
 
 	c := &Client{httpClient: &http.Client{}, URL: IcsURL}
 
+	for _, opt := range opts {
+		opt(c)
+	}
+
 	return c, nil
 }
 
-// GetResponse fetches a HTTP response from officeholldays site with country-local ICS as a body.
+// NewFileClient creates a client structure for local ICS file parse, for use without network access.
+func NewFileClient(path string) (*Client, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{URL: &url.URL{Scheme: SchemeFile, Path: filepath.ToSlash(absPath)}}
+
+	return c, nil
+}
+
+// GetResponse fetches a HTTP response from officeholldays site with country-local ICS as a body, or reads a local
+// ICS file for a file URL. Remote responses go through the response cache when configured.
 func (c *Client) GetResponse(ctx context.Context) (evs Events, err error) {
+	if c.URL.Scheme == SchemeFile {
+		return c.getFile()
+	}
+
+	if c.Cache != nil {
+		body, err := c.Cache.Get(ctx, c.httpClient, c.URL.String())
+		if err != nil {
+			return Events{}, err
+		}
+
+		return Decode(bytes.NewReader(body))
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL.String(), nil)
 	if err != nil {
 		return Events{}, err
@@ -116,18 +172,46 @@ func (c *Client) GetResponse(ctx context.Context) (evs Events, err error) {
 
 	// Handle HTTP errors before decoding body
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 20<<20))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
 
 		return Events{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
 	}
 
-	d := goics.NewDecoder(io.LimitReader(resp.Body, 20<<20))
-
 	// Parse received ICS
-	err = d.Decode(&evs)
+	evs, err = Decode(resp.Body)
+
+	return evs, err
+}
+
+// getFile reads and parses a local ICS file.
+func (c *Client) getFile() (evs Events, err error) {
+	f, err := os.Open(filepath.FromSlash(c.URL.Path))
 	if err != nil {
 		return Events{}, err
 	}
 
+	// Defer file close() with error propagation
+	defer func() {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	evs, err = Decode(f)
+
 	return evs, err
 }
+
+// Decode parses ICS calendar events from a reader.
+func Decode(r io.Reader) (Events, error) {
+	var evs Events
+
+	d := goics.NewDecoder(io.LimitReader(r, maxBodySize))
+
+	if err := d.Decode(&evs); err != nil {
+		return Events{}, err
+	}
+
+	return evs, nil
+}
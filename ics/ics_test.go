@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dkorunic/IM-billing-v2/ics"
@@ -195,3 +197,40 @@ func TestGetResponse_ContextCancelled(t *testing.T) {
 		t.Fatal("expected error for cancelled context, got nil")
 	}
 }
+
+func TestNewFileClient_LocalICS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+
+	if err := os.WriteFile(path, []byte(validICS), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	client, err := ics.NewFileClient(path)
+	if err != nil {
+		t.Fatalf("NewFileClient: %v", err)
+	}
+
+	if client.URL.Scheme != ics.SchemeFile {
+		t.Errorf("URL scheme: got %q, want %q", client.URL.Scheme, ics.SchemeFile)
+	}
+
+	events, err := client.GetResponse(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+}
+
+func TestNewFileClient_MissingFile(t *testing.T) {
+	client, err := ics.NewFileClient(filepath.Join(t.TempDir(), "missing.ics"))
+	if err != nil {
+		t.Fatalf("NewFileClient: %v", err)
+	}
+
+	if _, err = client.GetResponse(context.Background()); err == nil {
+		t.Fatal("expected error for missing file, got nil")
+	}
+}
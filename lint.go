@@ -0,0 +1,131 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/peterbourgon/ff/v4"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	DefaultMaxEventLength = 10 * time.Hour
+	DefaultDailyCap       = 12
+)
+
+var ErrLintFindings = errors.New("calendar problems found")
+
+var (
+	maxEventLength *time.Duration
+	dailyCap       *uint
+)
+
+// newLintCommand builds the lint command, inheriting report period and event selection flags from parent.
+func newLintCommand(parent *ff.FlagSet) *ff.Command {
+	lintFS := ff.NewFlagSet("lint").SetParent(parent)
+	maxEventLength = lintFS.DurationLong("max-event-length", DefaultMaxEventLength,
+		"longest plausible billed event, 0 disables the check")
+	dailyCap = lintFS.UintLong("daily-cap", DefaultDailyCap, "most plausible billed hours per day, 0 disables the check")
+
+	return &ff.Command{
+		Name:      "lint",
+		Usage:     "IM-billing-v2 lint [FLAGS]",
+		ShortHelp: "check calendar events of the period for problems that would corrupt billing",
+		Flags:     lintFS,
+		Exec:      runLint,
+	}
+}
+
+// runLint checks calendar events of all report sources together, since their billed hours are summed in a report,
+// and fails with ErrLintFindings if there are any findings.
+func runLint(ctx context.Context, _ []string) error {
+	sources, err := getReportSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := initialSync(ctx, sources); err != nil {
+		return err
+	}
+
+	apiCtx, apiCancel := context.WithTimeout(ctx, *apiTimeout)
+	defer apiCancel()
+
+	items, err := getEvents(apiCtx, sources)
+	if err != nil {
+		if apiCtx.Err() != nil {
+			return ErrAPITimeout
+		}
+
+		return err
+	}
+
+	q := newQuery(*calendarName)
+	findings := billing.Lint(q, items, billing.LintOptions{
+		MaxEventLength: *maxEventLength,
+		DailyCap:       int(*dailyCap),
+	})
+
+	printLintFindings(q, findings)
+
+	if len(findings) > 0 {
+		return fmt.Errorf("%w: %d", ErrLintFindings, len(findings))
+	}
+
+	return nil
+}
+
+// getEvents lists events of all report sources in the report period, synced into the local event store with a
+// cache directory like getReport.
+func getEvents(ctx context.Context, sources []calendarSource) ([]*calendar.Event, error) {
+	var store *billing.Store
+
+	if cacheDirFinal != "" {
+		s, err := openEventStore()
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = s.Close() }()
+
+		store = s
+	}
+
+	var items []*calendar.Event
+
+	for _, src := range sources {
+		srcItems, err := fetchEvents(ctx, store, src)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, srcItems...)
+	}
+
+	return items, nil
+}
+
+// printLintFindings displays lint findings of the query period.
+func printLintFindings(q billing.Query, findings []billing.Finding) {
+	period := fmt.Sprintf("%v project from %v to %v", reportCalendarName(), q.Start.Format(billing.DateLayout),
+		q.End.Format(billing.DateLayout))
+
+	if len(findings) == 0 {
+		fmt.Printf("No calendar problems found on %s\n", period)
+
+		return
+	}
+
+	fmt.Printf("Found %d calendar problems on %s:\n", len(findings), period)
+
+	for _, f := range findings {
+		fmt.Printf("%v\n", f)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+// setLintCalendar selects a single report calendar, named in lint output.
+func setLintCalendar(t *testing.T, name string) {
+	t.Helper()
+
+	origCalendarName, origAccountCalendars := calendarName, accountCalendarsFinal
+
+	t.Cleanup(func() { calendarName, accountCalendarsFinal = origCalendarName, origAccountCalendars })
+
+	calendarName, accountCalendarsFinal = &name, nil
+}
+
+func TestPrintLintFindings(t *testing.T) {
+	setLintCalendar(t, "TestCal")
+
+	q := billing.Query{
+		Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		End:   time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	findings := []billing.Finding{{
+		Start:   time.Date(2024, 1, 15, 10, 30, 0, 0, time.Local),
+		Check:   billing.CheckOverlap,
+		Message: `event "CLIENT: Review" overlaps "CLIENT: Design" by 30m0s, both are billed`,
+	}}
+
+	output := captureStdout(t, func() { printLintFindings(q, findings) })
+
+	for _, want := range []string{
+		"Found 1 calendar problems on TestCal project from 2024-01-01 to 2024-02-01:\n",
+		`2024-01-15 10:30 overlap: event "CLIENT: Review" overlaps "CLIENT: Design" by 30m0s, both are billed` + "\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+
+	output = captureStdout(t, func() { printLintFindings(q, nil) })
+	if !strings.HasPrefix(output, "No calendar problems found") {
+		t.Errorf("got %q, want no problems found", output)
+	}
+}
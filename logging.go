@@ -0,0 +1,44 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// newLogger returns a structured diagnostics logger writing to w in a log format at a log level; report output does
+// not go through it, so it stays clean on stdout.
+func newLogger(w io.Writer, level, format string) *slog.Logger {
+	var lvl slog.Level
+
+	// Log levels are validated by flag parsing, an unknown level stays at info
+	_ = lvl.UnmarshalText([]byte(level))
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	if format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// fatal logs an error with attributes and exits, like log.Fatal for structured logs.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(ExitFailure)
+}
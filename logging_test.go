@@ -0,0 +1,40 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := newLogger(&buf, logLevelWarn, logFormatText)
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "value")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "level=WARN msg=shown key=value") {
+		t.Errorf("got %q, want only the warning", out)
+	}
+}
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	newLogger(&buf, logLevelDebug, logFormatJSON).Debug("Listed events page", "page", 2)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Unmarshal %q: %v", buf.String(), err)
+	}
+
+	if record["level"] != "DEBUG" || record["msg"] != "Listed events page" || record["page"] != float64(2) {
+		t.Errorf("got %v, want a debug record with page 2", record)
+	}
+}
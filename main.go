@@ -7,34 +7,85 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/KimMachineGun/automemlimit/memlimit"
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/dkorunic/IM-billing-v2/cache"
+	"github.com/dkorunic/IM-billing-v2/geoip"
+	"github.com/dkorunic/IM-billing-v2/httpclient"
 	"github.com/dkorunic/IM-billing-v2/oauth"
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffhelp"
 	"github.com/peterbourgon/ff/v4/ffyaml"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
 )
 
 var (
-	calendarName, startDate, endDate, searchString *string
-	apiTimeout                                     *time.Duration
-	helpFlag, dashFlag, includeRecurring           *bool
-	startDateFinal, endDateFinal                   time.Time
+	calendarName, startDate, endDate, searchString   *string
+	outputFormat, outputFile, holidaySource          *string
+	cacheDir, workHoursRange, geoipDB                *string
+	proxyURL, caFile, userAgent, tlsMinVersion       *string
+	serviceAccount, impersonate, logLevel, logFormat *string
+	credentialsFile, tokenFile                       *string
+	tokenStore, tokenPassphrase, account             *string
+	apiTimeout, syncTimeout                          *time.Duration
+	hourlyRate, surchargeSaturday                    *float64
+	surchargeSunday, surchargeHoliday                *float64
+	surchargeAfterHours                              *float64
+	holidayCountries, holidayICS, geoipProviders     *[]string
+	accountCalendars                                 *[]string
+	helpFlag, dashFlag, includeRecurring, noCache    *bool
+	requireHolidays, verboseFlag, resyncFlag         *bool
+	offlineFlag, explainFlag                         *bool
+	startDateFinal, endDateFinal                     time.Time
+	cacheDirFinal, tokenFileFinal                    string
+	workHoursFinal                                   billing.WorkHours
+	offlineFinal                                     bool
+	httpClientFinal                                  *http.Client
+	accountCalendarsFinal                            []accountCalendar
+)
+
+var (
+	ErrAPITimeout     = errors.New("timeout fetching Google calendar API")
+	ErrBuiltinCountry = errors.New("builtin holiday source requires --holiday-country, GeoIP detection needs network access")
+)
+
+// Exit codes per error category, so wrapper scripts can tell a required login from an unavailable Google API.
+const (
+	ExitFailure          = 1
+	ExitUnauthorized     = 3
+	ExitCalendarNotFound = 4
+	ExitQuotaExceeded    = 5
+	ExitUnavailable      = 6
+	ExitTimeout          = 7
+	ExitLintFindings     = 8
 )
 
 const (
-	DefaultAPITimeout  = 60 * time.Second
-	DefaultCredentials = "assets/credentials.json"
-	maxMemRatio        = 0.9
+	DefaultAPITimeout   = 60 * time.Second
+	DefaultSyncTimeout  = 10 * time.Minute
+	DefaultCredentials  = "assets/credentials.json"
+	DefaultTokenFile    = "token.json"
+	DefaultEncTokenFile = "token.json.enc"
+	maxMemRatio         = 0.9
 )
 
+// holidayResult holds fetched holidays together with a holiday lookup error.
+type holidayResult struct {
+	holidayMap map[string]billing.Holiday
+	err        error
+}
+
 //go:embed assets/credentials.json
 var credentialFS embed.FS
 
@@ -50,68 +101,165 @@ func main() {
 		),
 	)
 
-	parseArgs()
+	cmd := parseArgs()
 
-	ctx := context.Background()
+	// OAuth2 token exchange, refresh and the authenticated Google client all use the context HTTP client
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClientFinal)
 	ctxWithCancel, cancelFunction := context.WithCancel(ctx)
 
 	defer cancelFunction()
 
-	// Load Calendar API credentials
-	b, err := credentialFS.ReadFile(DefaultCredentials)
-	if err != nil {
-		log.Fatalf("Unable to read credentials file: %v", err)
+	// Run the selected command, a calendar report by default
+	if err := cmd.Run(ctxWithCancel); err != nil {
+		cancelFunction()
+		slog.Error("Command failed", "error", err)
+		os.Exit(exitCode(err))
 	}
+}
 
-	// Parse Calendar API credentials
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+// exitCode returns the process exit code of an error category.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, billing.ErrUnauthorized), errors.Is(err, oauth.ErrOAuthAccessDenied):
+		return ExitUnauthorized
+	case errors.Is(err, billing.ErrCalendarNotFound):
+		return ExitCalendarNotFound
+	case errors.Is(err, billing.ErrQuotaExceeded):
+		return ExitQuotaExceeded
+	case errors.Is(err, billing.ErrUnavailable):
+		return ExitUnavailable
+	case errors.Is(err, ErrAPITimeout):
+		return ExitTimeout
+	case errors.Is(err, ErrLintFindings):
+		return ExitLintFindings
+	default:
+		return ExitFailure
 	}
+}
 
-	// Retrieve Calendar API user token
-	client, err := oauth.GetClient(ctxWithCancel, config, "token.json")
+// runReport fetches calendar events and public holidays and displays or writes the billing report.
+func runReport(ctx context.Context, _ []string) error {
+	// Authorize every account taking part in the report
+	sources, err := getReportSources(ctx)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token: %v", err)
+		return err
 	}
 
-	// Initialize Calendar client
-	srv, err := calendar.NewService(ctxWithCancel, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+	// A first sync lists the whole calendar history, so it has its own bound instead of the report timeout
+	if err := initialSync(ctx, sources); err != nil {
+		return err
 	}
 
 	// Bound API work by the timeout; OAuth stays un-timed so login is excluded.
 	// A derived context cancels in-flight requests, unlike a bare timer.
-	apiCtx, apiCancel := context.WithTimeout(ctxWithCancel, *apiTimeout)
+	apiCtx, apiCancel := context.WithTimeout(ctx, *apiTimeout)
 	defer apiCancel()
 
-	chanCalendar := make(chan struct{}, 1)
-	chanHolidays := make(chan map[string]holidayEvent, 1)
+	chanCalendar := make(chan error, 1)
+	chanHolidays := make(chan holidayResult, 1)
 
 	// Fetch Office holiday events
 	go func() {
-		chanHolidays <- getHolidayEvents(apiCtx)
+		var r holidayResult
+
+		r.holidayMap, r.err = getHolidayEvents(apiCtx, holidayOptions{
+			start:      startDateFinal,
+			end:        endDateFinal,
+			source:     *holidaySource,
+			countries:  *holidayCountries,
+			icsFiles:   *holidayICS,
+			cacheDir:   cacheDirFinal,
+			offline:    offlineFinal,
+			geoipOrder: *geoipProviders,
+			geoipDB:    *geoipDB,
+			httpClient: httpClientFinal,
+		})
+		chanHolidays <- r
 	}()
 
 	// Fetch Calendar events and display them
 	go func() {
-		eventMap := getCalendarEvents(apiCtx, srv, calendarName)
-		holidayMap := <-chanHolidays
-		printMonthlyStats(eventMap, holidayMap)
-		chanCalendar <- struct{}{}
+		report, err := getReport(apiCtx, sources)
+		if err != nil {
+			chanCalendar <- err
+
+			return
+		}
+
+		holidays := <-chanHolidays
+		report.Holidays = holidays.holidayMap
+
+		if *explainFlag {
+			printExplanations(report)
+		}
+
+		// Holiday lookup failures are only reported, unless explicitly required to succeed
+		if holidays.err != nil && *requireHolidays {
+			chanCalendar <- fmt.Errorf("holiday check failed: %w", holidays.err)
+
+			return
+		}
+
+		if *outputFormat == formatXLSX {
+			if err := writeSpreadsheet(*outputFile, report, holidays.err); err != nil {
+				chanCalendar <- fmt.Errorf("unable to write report: %w", err)
+
+				return
+			}
+
+			chanCalendar <- nil
+
+			return
+		}
+
+		printMonthlyStats(report, holidays.err)
+		chanCalendar <- nil
 	}()
 
 	// Wait for completion or timeout
 	select {
-	case <-chanCalendar:
+	case err := <-chanCalendar:
+		return err
 	case <-apiCtx.Done():
-		log.Fatal("Timeout fetching Google calendar API... Exiting.")
+		return ErrAPITimeout
+	}
+}
+
+// getOAuthConfig loads and parses Calendar API OAuth2 client credentials, falling back to embedded credentials.
+func getOAuthConfig() (*oauth2.Config, error) {
+	var (
+		b   []byte
+		err error
+	)
+
+	// Load Calendar API credentials
+	if *credentialsFile != "" {
+		b, err = os.ReadFile(*credentialsFile)
+	} else {
+		b, err = credentialFS.ReadFile(DefaultCredentials)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %w", err)
+	}
+
+	// Parse Calendar API credentials
+	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
+
+	// Client secret files carry no device authorization endpoint
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+
+	return config, nil
 }
 
-// parseArgs parses program arguments via ff and does minimal required sanity checking.
-func parseArgs() {
+// parseArgs parses program arguments via ff, does minimal required sanity checking and returns the parsed command
+// tree, ready to run the selected command.
+func parseArgs() *ff.Command {
 	fs := ff.NewFlagSet("IM-billing-v2")
 
 	calendarName = fs.String('c', "calendar", "", "calendar name")
@@ -119,30 +267,181 @@ func parseArgs() {
 	endDate = fs.String('e', "end", "", "end date (YYYY-MM-DD)")
 	searchString = fs.String('x', "search", "", "search string (prefix match in event description)")
 
+	outputFormat = fs.StringEnum('f', "format", "report format (text, xlsx)", formatText, formatXLSX)
+	outputFile = fs.String('o', "output", DefaultOutput, "spreadsheet report file (xlsx format only)")
+	hourlyRate = fs.Float64Long("rate", 0, "hourly rate used for billed amounts")
+	workHoursRange = fs.StringLong("work-hours", billing.DefaultWorkHours, "working hours (HH:MM-HH:MM), empty disables after-hours detection")
+	surchargeSaturday = fs.Float64Long("surcharge-saturday", 1, "billed amount multiplier for Saturday work")
+	surchargeSunday = fs.Float64Long("surcharge-sunday", 1, "billed amount multiplier for Sunday work")
+	surchargeHoliday = fs.Float64Long("surcharge-holiday", 1, "billed amount multiplier for public holiday work")
+	surchargeAfterHours = fs.Float64Long("surcharge-after-hours", 1, "billed amount multiplier for work outside working hours")
+
+	holidayCountries = fs.StringListLong("holiday-country", "holiday country ISO 3166-1 code (repeatable, default: GeoIP)")
+	holidaySource = fs.StringEnumLong("holiday-source", "holiday source (remote, builtin)", holidaySourceRemote, holidaySourceBuiltin)
+	holidayICS = fs.StringListLong("holiday-ics", "local holiday ICS file (repeatable, overrides holiday source)")
+	geoipProviders = fs.StringListLong("geoip-provider", "GeoIP provider in fallback order: ifconfig, ipinfo, ip-api, maxmind (repeatable, default: ifconfig,ipinfo,ip-api)")
+	geoipDB = fs.StringLong("geoip-mmdb", "", "MaxMind GeoLite2 / GeoIP2 .mmdb database for the maxmind GeoIP provider")
+
+	cacheDir = fs.StringLong("cache-dir", "", "holiday and GeoIP cache directory (default: user cache directory)")
+	noCache = fs.BoolLong("no-cache", "disable holiday and GeoIP cache and the local event store")
+	resyncFlag = fs.BoolLong("resync", "discard locally stored events and sync all events again")
+	offlineFlag = fs.BoolLong("offline", "report from locally stored events and cached holidays without network access")
+	requireHolidays = fs.BoolLong("require-holidays", "fail if holidays cannot be fetched")
+
+	proxyURL = fs.StringLong("proxy", "", "HTTP proxy URL (default: HTTP_PROXY/HTTPS_PROXY environment)")
+	caFile = fs.StringLong("ca-file", "", "PEM CA bundle trusted in addition to system roots")
+	userAgent = fs.StringLong("user-agent", httpclient.DefaultUserAgent, "HTTP User-Agent header")
+	tlsMinVersion = fs.StringEnumLong("tls-min-version", "minimum TLS version (1.2, 1.3)", httpclient.TLS12, httpclient.TLS13)
+
+	account = fs.StringLong("account", DefaultAccount, "named Google account whose stored token is used")
+	accountCalendars = fs.StringListLong("account-calendar", "calendar of a named account as ACCOUNT[:CALENDAR] (repeatable, combined into one report)")
+	credentialsFile = fs.StringLong("credentials", "", "OAuth2 client credentials JSON file (default: embedded credentials)")
+	tokenFile = fs.StringLong("token", "", "OAuth2 token file (default: user config directory)")
+	tokenStore = fs.StringEnumLong("token-store", "OAuth2 token storage (file, encrypted, keyring)", tokenStoreFile, tokenStoreEncrypted, tokenStoreKeyring)
+	tokenPassphrase = fs.StringLong("token-passphrase", "", "passphrase for encrypted token storage (prefer IMB_TOKEN_PASSPHRASE)")
+	serviceAccount = fs.StringLong("service-account", "", "service account JSON key, replaces interactive login")
+	impersonate = fs.StringLong("impersonate", "", "Google Workspace user impersonated through domain-wide delegation (service account only)")
+
 	_ = fs.StringLong("config", "", "config file (optional)")
 
 	apiTimeout = fs.Duration('t', "timeout", DefaultAPITimeout, "Google Calendar API timeout")
+	syncTimeout = fs.DurationLong("sync-timeout", DefaultSyncTimeout, "timeout of the first full sync of a calendar into the local event store")
 
 	helpFlag = fs.Bool('h', "help", "display help")
 	dashFlag = fs.Bool('d', "dash", "use dashes when printing totals")
 	includeRecurring = fs.Bool('r', "recurring", "include recurring events")
+	explainFlag = fs.BoolLong("explain", "print every listed event with its billing decision, reason and durations")
+	verboseFlag = fs.Bool('v', "verbose", "log debug details, same as --log-level debug")
+	logLevel = fs.StringEnumLong("log-level", "diagnostics log level (info, debug, warn, error)", logLevelInfo, logLevelDebug,
+		logLevelWarn, logLevelError)
+	logFormat = fs.StringEnumLong("log-format", "diagnostics log format on stderr (text, json)", logFormatText, logFormatJSON)
+
+	root := &ff.Command{
+		Name:        "IM-billing-v2",
+		Usage:       "IM-billing-v2 [FLAGS] [COMMAND]",
+		ShortHelp:   "Google calendar based billing report",
+		Flags:       fs,
+		Exec:        runReport,
+		Subcommands: []*ff.Command{newAuthCommand(fs), newLintCommand(fs)},
+	}
 
-	if err := ff.Parse(fs, os.Args[1:],
+	if err := root.Parse(os.Args[1:],
 		ff.WithEnvVarPrefix("IMB"),
 		ff.WithConfigFileFlag("config"),
 		ff.WithConfigFileParser(ffyaml.Parser{}.Parse)); err != nil {
-		fmt.Printf("%s\n", ffhelp.Flags(fs))
-		fmt.Printf("Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", ffhelp.Command(root.GetSelected()))
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 
-		os.Exit(1)
+		os.Exit(ExitFailure)
 	}
 
 	if *helpFlag {
-		fmt.Printf("%s\n", ffhelp.Flags(fs))
+		fmt.Printf("%s\n", ffhelp.Command(root.GetSelected()))
 
 		os.Exit(0)
 	}
 
+	// Diagnostics go to stderr, so report output on stdout can be piped
+	level := *logLevel
+	if *verboseFlag {
+		level = logLevelDebug
+	}
+
+	slog.SetDefault(newLogger(os.Stderr, level, *logFormat))
+
+	// Impersonation is only possible through a service account
+	if *impersonate != "" && *serviceAccount == "" {
+		fatal("Impersonation requires a service account key (--service-account)")
+	}
+
+	// Resolve account token file; without a user config directory fall back to the working directory
+	if err := validateAccount(*account); err != nil {
+		fatal("Invalid account", "error", err)
+	}
+
+	tokenFileFinal = *tokenFile
+	if tokenFileFinal == "" {
+		tokenFileFinal = accountTokenFile(*account)
+	}
+
+	// Parse calendars of named accounts combined into a single report
+	accountCalendarsFinal = nil
+
+	for _, v := range *accountCalendars {
+		ac, err := parseAccountCalendar(v)
+		if err != nil {
+			fatal("Invalid account calendar", "error", err)
+		}
+
+		accountCalendarsFinal = append(accountCalendarsFinal, ac)
+	}
+
+	// A single token file or a service account cannot serve several named accounts
+	if len(accountCalendarsFinal) > 0 && (*tokenFile != "" || *serviceAccount != "") {
+		fatal("Account calendars cannot be combined with --token or --service-account")
+	}
+
+	// Encrypted token storage is useless without a passphrase
+	if *tokenStore == tokenStoreEncrypted && *tokenPassphrase == "" {
+		fatal("Encrypted token storage requires a passphrase (--token-passphrase or IMB_TOKEN_PASSPHRASE)")
+	}
+
+	// Normalize and validate holiday country codes
+	countries, err := normalizeCountries(*holidayCountries)
+	if err != nil {
+		fatal("Invalid holiday country", "error", err)
+	}
+
+	*holidayCountries = countries
+
+	// The builtin holiday source works without network access, so it must not fall back to a GeoIP lookup
+	if err := validateHolidaySource(*holidaySource, *holidayCountries, *holidayICS); err != nil {
+		fatal("Invalid holiday source", "error", err)
+	}
+
+	// Split comma-separated GeoIP provider order, falling back to default providers
+	*geoipProviders = splitList(*geoipProviders)
+	if len(*geoipProviders) == 0 {
+		*geoipProviders = slices.Clone(geoip.DefaultProviders)
+	}
+
+	// Parse working hours used for after-hours work detection
+	if *workHoursRange != "" {
+		wh, err := billing.ParseWorkHours(*workHoursRange)
+		if err != nil {
+			fatal("Invalid working hours", "error", err)
+		}
+
+		workHoursFinal = wh
+	}
+
+	// Negative rate or multipliers would silently reduce billed amounts
+	for _, v := range []*float64{hourlyRate, surchargeSaturday, surchargeSunday, surchargeHoliday, surchargeAfterHours} {
+		if *v < 0 {
+			fatal("Hourly rate and surcharge multipliers must not be negative", "value", *v)
+		}
+	}
+
+	// Resolve cache directory; caching is best-effort, so a missing user cache directory just disables it
+	if !*noCache {
+		cacheDirFinal = *cacheDir
+		if cacheDirFinal == "" {
+			cacheDirFinal, _ = cache.DefaultDir()
+		}
+	}
+
+	// Offline reports are built from the local event store and cached responses only
+	offlineFinal = *offlineFlag
+	if offlineFinal {
+		if cacheDirFinal == "" {
+			fatal("Offline mode requires the cache directory and cannot be combined with --no-cache")
+		}
+
+		if *resyncFlag {
+			fatal("Offline mode cannot be combined with --resync")
+		}
+	}
+
 	// By default, set start date to the 1st of previous month and end date to the 1st of current month
 	t := time.Now()
 	startDateFinal = time.Date(t.Year(), t.Month()-1, 1, 0, 0, 0, 0, time.Local)
@@ -150,9 +449,9 @@ func parseArgs() {
 
 	// Convert starting date in regard to local timezone
 	if *startDate != "" {
-		t, err := time.ParseInLocation(dateLayout, *startDate, time.Local)
+		t, err := time.ParseInLocation(billing.DateLayout, *startDate, time.Local)
 		if err != nil {
-			log.Fatalf("Cannot parse start time: %v", err)
+			fatal("Cannot parse start time", "error", err)
 		}
 
 		startDateFinal = t
@@ -160,9 +459,9 @@ func parseArgs() {
 
 	// Convert ending date in regards to local timezone
 	if *endDate != "" {
-		t, err := time.ParseInLocation(dateLayout, *endDate, time.Local)
+		t, err := time.ParseInLocation(billing.DateLayout, *endDate, time.Local)
 		if err != nil {
-			log.Fatalf("Cannot parse end time: %v", err)
+			fatal("Cannot parse end time", "error", err)
 		}
 
 		endDateFinal = t
@@ -170,6 +469,72 @@ func parseArgs() {
 
 	// Check if dates are swapped
 	if endDateFinal.Sub(startDateFinal) < 0 {
-		log.Fatalf("End date (%v) is before start date (%v)\n", endDateFinal, startDateFinal)
+		fatal("End date is before start date", "start", startDateFinal.Format(billing.DateLayout),
+			"end", endDateFinal.Format(billing.DateLayout))
+	}
+
+	// Shared HTTP transport for GeoIP, holiday ICS and Google API requests
+	httpClient, err := httpclient.New(httpclient.Options{
+		ProxyURL:      *proxyURL,
+		CAFile:        *caFile,
+		UserAgent:     *userAgent,
+		TLSMinVersion: *tlsMinVersion,
+	})
+	if err != nil {
+		fatal("Unable to configure HTTP client", "error", err)
+	}
+
+	httpClientFinal = httpClient
+
+	// Guard offline mode against any request slipping through
+	if offlineFinal {
+		httpClientFinal = httpclient.Offline()
+	}
+
+	return root
+}
+
+// splitList splits comma-separated values and drops empty ones, so that a list fits a single environment variable.
+func splitList(values []string) []string {
+	var list []string
+
+	for _, v := range values {
+		for item := range strings.SplitSeq(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
 	}
+
+	return list
+}
+
+// normalizeCountries upper-cases, de-duplicates and validates ISO 3166-1 alpha-2 country codes. Comma-separated
+// values are accepted so that a list fits a single environment variable.
+func normalizeCountries(values []string) ([]string, error) {
+	var countries []string
+
+	for _, c := range splitList(values) {
+		c = strings.ToUpper(c)
+
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return nil, fmt.Errorf("%q is not an ISO 3166-1 alpha-2 code", c)
+		}
+
+		if !slices.Contains(countries, c) {
+			countries = append(countries, c)
+		}
+	}
+
+	return countries, nil
+}
+
+// validateHolidaySource checks that the builtin holiday source has explicit holiday countries instead of a GeoIP
+// lookup over the network. Local ICS files override the holiday source and need no country.
+func validateHolidaySource(source string, countries, icsFiles []string) error {
+	if source == holidaySourceBuiltin && len(countries) == 0 && len(icsFiles) == 0 {
+		return ErrBuiltinCountry
+	}
+
+	return nil
 }
@@ -5,9 +5,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+	"github.com/dkorunic/IM-billing-v2/oauth"
 )
 
 // TC-18: without explicit --start/--end flags, startDateFinal must be the 1st of
@@ -54,3 +61,137 @@ func TestParseArgs_ValidDateRangeAccepted(t *testing.T) {
 		t.Errorf("endDateFinal: got %v, want %v", endDateFinal, wantEnd)
 	}
 }
+
+func TestNormalizeCountries(t *testing.T) {
+	got, err := normalizeCountries([]string{"hr", " de,AT ", "HR", ""})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"HR", "DE", "AT"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNormalizeCountries_Invalid(t *testing.T) {
+	for _, v := range []string{"HRV", "H", "1A"} {
+		if _, err := normalizeCountries([]string{v}); err == nil {
+			t.Errorf("expected error for %q, got nil", v)
+		}
+	}
+}
+
+func TestValidateHolidaySource(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		countries []string
+		icsFiles  []string
+		want      error
+	}{
+		{"builtin without country", holidaySourceBuiltin, nil, nil, ErrBuiltinCountry},
+		{"builtin with country", holidaySourceBuiltin, []string{"HR"}, nil, nil},
+		{"builtin overridden by ICS", holidaySourceBuiltin, nil, []string{"holidays.ics"}, nil},
+		{"remote without country", holidaySourceRemote, nil, nil, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateHolidaySource(tc.source, tc.countries, tc.icsFiles); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseArgs_TokenDefaultsToConfigDir(t *testing.T) {
+	origArgs := os.Args
+	t.Cleanup(func() { os.Args = origArgs })
+
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("IMB_TOKEN", "")
+
+	os.Args = []string{"IM-billing-v2"}
+
+	parseArgs()
+
+	if want := filepath.Join(configHome, "IM-billing-v2", DefaultTokenFile); tokenFileFinal != want {
+		t.Errorf("tokenFileFinal: got %q, want %q", tokenFileFinal, want)
+	}
+
+	os.Args = []string{"IM-billing-v2", "--token", "custom.json"}
+
+	parseArgs()
+
+	if tokenFileFinal != "custom.json" {
+		t.Errorf("tokenFileFinal: got %q, want custom.json", tokenFileFinal)
+	}
+}
+
+func TestGetOAuthConfig_CredentialsFile(t *testing.T) {
+	origCredentials := credentialsFile
+	t.Cleanup(func() { credentialsFile = origCredentials })
+
+	empty := ""
+	credentialsFile = &empty
+
+	embedded, err := getOAuthConfig()
+	if err != nil {
+		t.Fatalf("embedded credentials: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	custom := `{"installed":{"client_id":"custom-id","client_secret":"secret",` +
+		`"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token",` +
+		`"redirect_uris":["http://localhost"]}}`
+
+	if err := os.WriteFile(path, []byte(custom), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	credentialsFile = &path
+
+	config, err := getOAuthConfig()
+	if err != nil {
+		t.Fatalf("credentials file: %v", err)
+	}
+
+	if config.ClientID != "custom-id" || config.ClientID == embedded.ClientID {
+		t.Errorf("ClientID: got %q, want custom-id", config.ClientID)
+	}
+
+	if config.Endpoint.DeviceAuthURL == "" {
+		t.Error("DeviceAuthURL must default to the Google device endpoint")
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	credentialsFile = &missing
+
+	if _, err := getOAuthConfig(); err == nil {
+		t.Error("expected error for missing credentials file")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("other"), ExitFailure},
+		{fmt.Errorf("account work: %w: %w", billing.ErrEventList, billing.ErrUnauthorized), ExitUnauthorized},
+		{fmt.Errorf("login failed: %w", oauth.ErrOAuthAccessDenied), ExitUnauthorized},
+		{fmt.Errorf("%w: %q", billing.ErrCalendarNotFound, "Work"), ExitCalendarNotFound},
+		{fmt.Errorf("%w: %w", billing.ErrEventList, billing.ErrQuotaExceeded), ExitQuotaExceeded},
+		{fmt.Errorf("%w: %w", billing.ErrCalendarList, billing.ErrUnavailable), ExitUnavailable},
+		{ErrAPITimeout, ExitTimeout},
+		{fmt.Errorf("%w: %d", ErrLintFindings, 3), ExitLintFindings},
+	}
+
+	for _, tc := range tests {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v): got %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
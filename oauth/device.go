@@ -0,0 +1,61 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrOAuthDeviceAuth  = errors.New("unable to start device authorization")
+	ErrOAuthDeviceToken = errors.New("unable to retrieve token through device authorization")
+	ErrOAuthDeviceScope = errors.New("the device authorization flow does not allow the requested scopes, " +
+		"Google excludes Calendar scopes from it; log in through the browser, or with --paste on a remote host")
+)
+
+// errInvalidScope is the OAuth error code of scopes not allowed for the client or the flow.
+const errInvalidScope = "invalid_scope"
+
+// getTokenFromDevice runs the OAuth 2.0 device authorization grant (RFC 8628): it prints the verification URL and
+// user code to w, then polls the token endpoint until the user approves access on another device.
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, w io.Writer) (*oauth2.Token, error) {
+	da, err := config.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == errInvalidScope {
+			return nil, fmt.Errorf("%w: %w", ErrOAuthDeviceScope, err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrOAuthDeviceAuth, err)
+	}
+
+	_, _ = fmt.Fprintf(w, "To authorize access, open %s on any device and enter code: %s\n",
+		da.VerificationURI, da.UserCode)
+
+	if da.VerificationURIComplete != "" {
+		_, _ = fmt.Fprintf(w, "Alternatively, open %s\n", da.VerificationURIComplete)
+	}
+
+	if !da.Expiry.IsZero() {
+		_, _ = fmt.Fprintf(w, "The code expires at %s. Waiting for authorization...\n", da.Expiry.Format("15:04:05"))
+	}
+
+	slog.Debug("Polling for device authorization", "interval", time.Duration(da.Interval)*time.Second)
+
+	// Polls with the server-provided interval, backing off on slow_down, until approved, denied or expired
+	tok, err := config.DeviceAccessToken(ctx, da)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthDeviceToken, err)
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newDeviceServer fakes a device authorization and token endpoint. The token endpoint reports
+// authorization_pending pending times before issuing a token, or tokenErr if set.
+func newDeviceServer(t *testing.T, pending int32, tokenErr string) (*httptest.Server, *oauth2.Config) {
+	t.Helper()
+
+	var polls atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"device_code":"dev-code","user_code":"ABCD-EFGH",` +
+			`"verification_url":"https://example.com/device","expires_in":60,"interval":1}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if tokenErr != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"` + tokenErr + `"}`))
+
+			return
+		}
+
+		if polls.Add(1) <= pending {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"authorization_pending"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"access_token":"device-access","refresh_token":"device-refresh",` +
+			`"token_type":"Bearer","expires_in":3600}`))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	config := &oauth2.Config{
+		ClientID: "client-id",
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: srv.URL + "/device",
+			TokenURL:      srv.URL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+
+	return srv, config
+}
+
+func TestGetTokenFromDevice_PendingThenApproved(t *testing.T) {
+	_, config := newDeviceServer(t, 1, "")
+
+	var out bytes.Buffer
+
+	tok, err := getTokenFromDevice(context.Background(), config, &out)
+	if err != nil {
+		t.Fatalf("getTokenFromDevice: %v", err)
+	}
+
+	if tok.AccessToken != "device-access" || tok.RefreshToken != "device-refresh" {
+		t.Errorf("token: got %q/%q, want device-access/device-refresh", tok.AccessToken, tok.RefreshToken)
+	}
+
+	if !strings.Contains(out.String(), "https://example.com/device") || !strings.Contains(out.String(), "ABCD-EFGH") {
+		t.Errorf("instructions missing verification URL or user code: %q", out.String())
+	}
+}
+
+func TestGetTokenFromDevice_Denied(t *testing.T) {
+	_, config := newDeviceServer(t, 0, "access_denied")
+
+	_, err := getTokenFromDevice(context.Background(), config, &bytes.Buffer{})
+	if !errors.Is(err, ErrOAuthDeviceToken) {
+		t.Fatalf("error: got %v, want ErrOAuthDeviceToken", err)
+	}
+}
+
+func TestGetTokenFromDevice_InvalidScope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_scope","error_description":"Invalid device flow scope"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	config := &oauth2.Config{ClientID: "client-id", Endpoint: oauth2.Endpoint{DeviceAuthURL: srv.URL}}
+
+	_, err := getTokenFromDevice(context.Background(), config, &bytes.Buffer{})
+	if !errors.Is(err, ErrOAuthDeviceScope) {
+		t.Fatalf("error: got %v, want ErrOAuthDeviceScope", err)
+	}
+}
+
+func TestGetTokenFromDevice_NoEndpoint(t *testing.T) {
+	config := &oauth2.Config{ClientID: "client-id"}
+
+	_, err := getTokenFromDevice(context.Background(), config, &bytes.Buffer{})
+	if !errors.Is(err, ErrOAuthDeviceAuth) {
+		t.Fatalf("error: got %v, want ErrOAuthDeviceAuth", err)
+	}
+}
+
+func TestLogin_DeviceSavesToken(t *testing.T) {
+	_, config := newDeviceServer(t, 0, "")
+
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	opts := LoginOptions{Output: &bytes.Buffer{}, Device: true}
+
+	if err := Login(context.Background(), config, &FileStore{Path: path}, opts); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	tok, err := tokenFromFile(path)
+	if err != nil {
+		t.Fatalf("tokenFromFile: %v", err)
+	}
+
+	if tok.AccessToken != "device-access" {
+		t.Errorf("saved AccessToken: got %q, want device-access", tok.AccessToken)
+	}
+}
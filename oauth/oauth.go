@@ -5,33 +5,38 @@
 package oauth
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/renameio/v2/maybe"
 	"github.com/google/uuid"
-	"github.com/phayes/freeport"
 	"github.com/pkg/browser"
 	"golang.org/x/oauth2"
 )
 
 const (
 	AuthTimeout       = 90 * time.Second
+	ManualAuthTimeout = 5 * time.Minute
 	AuthListenAddr    = "127.0.0.1"
 	AuthScheme        = "http://"
 	DefaultPerms      = 0o600
+	DefaultDirPerms   = 0o700
 	ReadTimeout       = 5 * time.Second
 	WriteTimeout      = 5 * time.Second
 	IdleTimeout       = 60 * time.Second
@@ -39,23 +44,29 @@ const (
 )
 
 var (
-	ErrOAuthUUID          = errors.New("unable to generate UUID")
-	ErrOAuthFreePort      = errors.New("unable to get a free port")
-	ErrOAuthHTTPServer    = errors.New("unable to start HTTP server")
-	ErrOAuthBrowser       = errors.New("unable to open system browser")
-	ErrOAuthTimeout       = errors.New("timeout while waiting for authentication to finish")
-	ErrOAuthTokenFetch    = errors.New("unable to retrieve token from Google API")
-	ErrOAuthTokenSave     = errors.New("unable to save token to file")
-	ErrOAuthTokenEncode   = errors.New("unable to encode OAuth token to JSON")
+	ErrOAuthUUID         = errors.New("unable to generate UUID")
+	ErrOAuthHTTPServer   = errors.New("unable to start HTTP server")
+	ErrOAuthBrowser      = errors.New("unable to open system browser")
+	ErrOAuthState        = errors.New("invalid authentication state")
+	ErrOAuthNoCode       = errors.New("no authorization code found")
+	ErrOAuthAccessDenied = errors.New("access denied by user")
+	ErrOAuthTimeout      = errors.New("timeout while waiting for authentication to finish")
+	ErrOAuthTokenFetch   = errors.New("unable to retrieve token from Google API")
+	ErrOAuthTokenSave    = errors.New("unable to save token to file")
+	ErrOAuthTokenEncode  = errors.New("unable to encode OAuth token to JSON")
 )
 
-// GetClient returns an authenticated HTTP client, loading the token from tokenPath,
-// refreshing it if expired, or running the interactive browser flow if needed.
-func GetClient(ctx context.Context, config *oauth2.Config, tokenPath string) (*http.Client, error) {
-	tok, err := tokenFromFile(tokenPath)
+// GetClient returns an authenticated HTTP client, loading the token from store,
+// refreshing it if expired, or running the interactive flow configured by opts if needed.
+func GetClient(ctx context.Context, config *oauth2.Config, store TokenStore, opts LoginOptions) (*http.Client,
+	error,
+) {
+	tok, err := store.Load()
 	saveToFile := false
 
 	if err == nil {
+		slog.Debug("Loaded OAuth token", "valid", tok.Valid(), "expiry", tok.Expiry)
+
 		// we have a token, but it has expired so attempt to refresh it
 		if !tok.Valid() {
 			src := config.TokenSource(ctx, tok)
@@ -63,15 +74,19 @@ func GetClient(ctx context.Context, config *oauth2.Config, tokenPath string) (*h
 			// refresh token
 			newTok, err := src.Token()
 			if err != nil {
+				slog.Debug("OAuth token refresh failed, starting interactive login", "error", err)
+
 				// Refresh failed (e.g. missing or revoked refresh token);
-				// fall back to interactive browser flow
-				tok, err = getTokenFromWeb(ctx, config)
+				// fall back to interactive flow
+				tok, err = login(ctx, config, opts)
 				if err != nil {
 					return nil, err
 				}
 			} else {
 				// token has been refreshed, always persist it to capture any
 				// updated expiry or rotated refresh token
+				slog.Debug("Refreshed OAuth token", "expiry", newTok.Expiry)
+
 				tok = newTok
 			}
 
@@ -79,7 +94,9 @@ func GetClient(ctx context.Context, config *oauth2.Config, tokenPath string) (*h
 		}
 	} else {
 		// we don't have a token, so we will obtain interactively
-		tok, err = getTokenFromWeb(ctx, config)
+		slog.Debug("No stored OAuth token, starting interactive login", "error", err)
+
+		tok, err = login(ctx, config, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -88,7 +105,7 @@ func GetClient(ctx context.Context, config *oauth2.Config, tokenPath string) (*h
 	}
 
 	if saveToFile {
-		if err = saveToken(tokenPath, tok); err != nil {
+		if err = store.Save(tok); err != nil {
 			return nil, err
 		}
 	}
@@ -96,38 +113,88 @@ func GetClient(ctx context.Context, config *oauth2.Config, tokenPath string) (*h
 	return config.Client(ctx, tok), nil
 }
 
+// openBrowser opens a URL in the system browser; replaceable in tests.
+var openBrowser = browser.OpenURL
+
+// LoginOptions configures an interactive login.
+type LoginOptions struct {
+	Output io.Writer // destination for device authorization instructions
+	Input  io.Reader // source of pasted redirect URLs or codes, defaults to os.Stdin
+	Device bool      // use the device authorization grant instead of the browser flow
+	Paste  bool      // also accept the redirected URL or authorization code pasted into Input
+}
+
+// Login always runs an interactive flow, either through the system browser or the device authorization grant, and
+// saves the new token to store, replacing any existing token.
+func Login(ctx context.Context, config *oauth2.Config, store TokenStore, opts LoginOptions) error {
+	tok, err := login(ctx, config, opts)
+	if err != nil {
+		return err
+	}
+
+	return store.Save(tok)
+}
+
+// login runs the interactive flow selected by opts and returns the new token.
+func login(ctx context.Context, config *oauth2.Config, opts LoginOptions) (*oauth2.Token, error) {
+	if opts.Device {
+		output := opts.Output
+		if output == nil {
+			output = os.Stderr
+		}
+
+		return getTokenFromDevice(ctx, config, output)
+	}
+
+	var input io.Reader
+
+	if opts.Paste {
+		input = opts.Input
+		if input == nil {
+			input = os.Stdin
+		}
+	}
+
+	return getTokenFromWeb(ctx, config, input)
+}
+
 // getTokenFromWeb runs the interactive OAuth2 flow: it opens the system browser,
 // serves a local callback to capture the auth code, and exchanges it for a token.
-func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+// If the browser cannot be opened, it prints the URL and keeps waiting for the callback.
+// A non-nil input is read concurrently for a pasted redirect URL or authorization code.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config, input io.Reader) (*oauth2.Token, error) {
 	// random UUID as a state
 	authReqState, err := uuid.NewV7()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrOAuthUUID, err)
 	}
 
+	// PKCE (RFC 7636) binds the authorization code to this flow, so an intercepted code cannot be exchanged
+	verifier := oauth2.GenerateVerifier()
+
 	tokChan := make(chan string, 1)
 	errChan := make(chan error, 1)
 
 	var once sync.Once
 
-	// get a free random port
-	authListenPort, err := freeport.GetFreePort()
+	// bind to a random free port before deriving the redirect uri, so the port cannot be taken in between
+	ln, err := net.Listen("tcp", net.JoinHostPort(AuthListenAddr, "0"))
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrOAuthFreePort, err)
+		return nil, fmt.Errorf("%w: %w", ErrOAuthHTTPServer, err)
 	}
 
-	// redirect uri listener for auth callback
-	authListenHost := net.JoinHostPort(AuthListenAddr, strconv.Itoa(authListenPort))
+	authListenPort := ln.Addr().(*net.TCPAddr).Port
+
+	slog.Debug("Listening for OAuth callback", "addr", ln.Addr().String())
 
 	// oauth config auth redirect uri
-	config.RedirectURL = AuthScheme + authListenHost
+	config.RedirectURL = AuthScheme + net.JoinHostPort(AuthListenAddr, strconv.Itoa(authListenPort))
 
 	s := http.Server{
 		ReadTimeout:       ReadTimeout,
 		WriteTimeout:      WriteTimeout,
 		IdleTimeout:       IdleTimeout,
 		ReadHeaderTimeout: ReadHeaderTimeout,
-		Addr:              authListenHost,
 	}
 	defer func() {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -138,16 +205,27 @@ func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token,
 	// oauth callback handler
 	r := chi.NewRouter()
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
 		// Reject mismatched state but keep waiting; a stray or CSRF request
 		// must not consume the one-shot success path or abort the flow.
-		if r.URL.Query().Get("state") != authReqState.String() {
+		if q.Get("state") != authReqState.String() {
 			http.Error(w, "Invalid authentication state", http.StatusUnauthorized)
 
 			return
 		}
 
+		// Authorization server reported an error, such as access denied by the user
+		if cbErr := callbackError(q); cbErr != nil {
+			once.Do(func() { errChan <- cbErr })
+
+			http.Error(w, "Authentication failed: "+cbErr.Error(), http.StatusBadRequest)
+
+			return
+		}
+
 		once.Do(func() {
-			tokChan <- r.URL.Query().Get("code")
+			tokChan <- q.Get("code")
 		})
 
 		_, _ = io.WriteString(w, "Authentication complete, you can close this window.\n")
@@ -160,22 +238,50 @@ func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token,
 
 	// oauth callback server
 	go func() {
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := s.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			once.Do(func() { errChan <- fmt.Errorf("%w: %w", ErrOAuthHTTPServer, err) })
 		}
 	}()
 
-	authCodeURL := config.AuthCodeURL(authReqState.String(), oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-	log.Printf("Opening auth URL through system browser: %v", authCodeURL)
+	// manual code entry from pasted redirect URLs or codes
+	if input != nil {
+		go readAuthCode(input, authReqState.String(), func(code string) {
+			once.Do(func() { tokChan <- code })
+		}, func(err error) {
+			once.Do(func() { errChan <- err })
+		})
+	}
 
-	// oauth dialog through system browser
-	if err := browser.OpenURL(authCodeURL); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrOAuthBrowser, err)
+	authCodeURL := config.AuthCodeURL(authReqState.String(), oauth2.AccessTypeOffline, oauth2.ApprovalForce,
+		oauth2.S256ChallengeOption(verifier))
+	// The auth URL and paste instructions are prompts, shown whatever the log level
+	_, _ = fmt.Fprintf(os.Stderr, "Opening auth URL through system browser: %v\n", authCodeURL)
+
+	timeout := AuthTimeout
+	if input != nil {
+		timeout = ManualAuthTimeout
+	}
+
+	// oauth dialog through system browser, falling back to opening the URL manually
+	if err := openBrowser(authCodeURL); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v: %v\n", ErrOAuthBrowser, err)
+		_, _ = fmt.Fprintf(os.Stderr, "Open the auth URL above on any machine. On a remote host, forward the callback "+
+			"port first, e.g. ssh -L %d:%s:%d <host>\n", authListenPort, AuthListenAddr, authListenPort)
+
+		if input != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Alternatively, paste the redirected URL or the authorization code here and "+
+				"press Enter.\n")
+		}
+
+		timeout = ManualAuthTimeout
+	} else if input != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "If the browser cannot reach this host, paste the redirected URL or the "+
+			"authorization code here and press Enter.\n")
 	}
 
 	var authCode string
 
-	ticker := time.NewTimer(AuthTimeout)
+	ticker := time.NewTimer(timeout)
 	defer ticker.Stop()
 
 	select {
@@ -187,7 +293,9 @@ func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token,
 		return nil, ErrOAuthTimeout
 	}
 
-	tok, err := config.Exchange(ctx, authCode)
+	slog.Debug("Received OAuth authorization code, exchanging it for a token")
+
+	tok, err := config.Exchange(ctx, authCode, oauth2.VerifierOption(verifier))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenFetch, err)
 	}
@@ -195,6 +303,97 @@ func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token,
 	return tok, nil
 }
 
+// CallbackError is an error reported by the authorization server through the redirect, such as access_denied.
+type CallbackError struct {
+	Code        string
+	Description string
+}
+
+// Error returns the OAuth2 error code with its description.
+func (e *CallbackError) Error() string {
+	if e.Description == "" {
+		return "authorization failed: " + e.Code
+	}
+
+	return "authorization failed: " + e.Code + ": " + e.Description
+}
+
+// Is reports access_denied errors as ErrOAuthAccessDenied.
+func (e *CallbackError) Is(target error) bool {
+	return target == ErrOAuthAccessDenied && e.Code == "access_denied"
+}
+
+// callbackError returns the error reported in redirect query parameters, or nil.
+func callbackError(q url.Values) error {
+	code := q.Get("error")
+	if code == "" {
+		return nil
+	}
+
+	return &CallbackError{Code: code, Description: q.Get("error_description")}
+}
+
+// readAuthCode reads lines from r until one holds a valid pasted redirect URL or authorization code,
+// which is passed to found, or a redirect URL with an authorization error, which is passed to failed.
+// Invalid lines are reported and skipped.
+func readAuthCode(r io.Reader, state string, found func(string), failed func(error)) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		code, err := parseAuthCode(line, state)
+		if cbErr := (*CallbackError)(nil); errors.As(err, &cbErr) {
+			failed(err)
+
+			return
+		}
+
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Ignoring pasted input: %v\n", err)
+
+			continue
+		}
+
+		found(code)
+
+		return
+	}
+}
+
+// parseAuthCode extracts the authorization code from a pasted redirect URL, verifying its state,
+// or returns the input as-is if it is a bare code.
+func parseAuthCode(input, state string) (string, error) {
+	if !strings.Contains(input, "?") {
+		return input, nil
+	}
+
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrOAuthNoCode, err)
+	}
+
+	q := u.Query()
+
+	if q.Get("state") != state {
+		return "", ErrOAuthState
+	}
+
+	if err := callbackError(q); err != nil {
+		return "", err
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		return "", ErrOAuthNoCode
+	}
+
+	return code, nil
+}
+
 // tokenFromFile reads and JSON-decodes an OAuth2 token from tokenPath.
 func tokenFromFile(tokenPath string) (*oauth2.Token, error) {
 	b, err := os.ReadFile(tokenPath)
@@ -209,7 +408,7 @@ func tokenFromFile(tokenPath string) (*oauth2.Token, error) {
 	return tok, err
 }
 
-// saveToken atomically writes the JSON-encoded OAuth2 token to tokenPath.
+// saveToken atomically writes the JSON-encoded OAuth2 token to tokenPath, creating its directory if needed.
 func saveToken(tokenPath string, token *oauth2.Token) error {
 	buf := new(bytes.Buffer)
 
@@ -218,6 +417,10 @@ func saveToken(tokenPath string, token *oauth2.Token) error {
 		return fmt.Errorf("%w: %w", ErrOAuthTokenEncode, err)
 	}
 
+	if err = os.MkdirAll(filepath.Dir(tokenPath), DefaultDirPerms); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
+	}
+
 	if err = maybe.WriteFile(tokenPath, buf.Bytes(), DefaultPerms); err != nil {
 		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
 	}
@@ -110,6 +110,23 @@ func TestSaveToken_Valid(t *testing.T) {
 	}
 }
 
+func TestSaveToken_CreatesDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "IM-billing-v2", "token.json")
+
+	if err := saveToken(path, &oauth2.Token{AccessToken: "nested"}); err != nil {
+		t.Fatalf("saveToken: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != DefaultDirPerms {
+		t.Errorf("directory permissions: got %o, want %o", perm, DefaultDirPerms)
+	}
+}
+
 // TC-14: an expired token must trigger a refresh attempt via the token endpoint.
 func TestGetClient_ExpiredTokenIsRefreshed(t *testing.T) {
 	const newAccessToken = "refreshed-access-token"
@@ -140,7 +157,7 @@ func TestGetClient_ExpiredTokenIsRefreshed(t *testing.T) {
 		t.Fatalf("saveToken: %v", err)
 	}
 
-	client, err := GetClient(context.Background(), config, tokenPath)
+	client, err := GetClient(context.Background(), config, &FileStore{Path: tokenPath}, LoginOptions{})
 	if err != nil {
 		t.Fatalf("GetClient: %v", err)
 	}
@@ -181,7 +198,7 @@ func TestGetClient_RefreshedTokenSavedToFile(t *testing.T) {
 		t.Fatalf("saveToken: %v", err)
 	}
 
-	if _, err := GetClient(context.Background(), config, tokenPath); err != nil {
+	if _, err := GetClient(context.Background(), config, &FileStore{Path: tokenPath}, LoginOptions{}); err != nil {
 		t.Fatalf("GetClient: %v", err)
 	}
 
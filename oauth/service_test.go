@@ -0,0 +1,138 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeServiceAccountKey writes a service account JSON key using tokenURI and returns its path.
+func writeServiceAccountKey(t *testing.T, tokenURI string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	b, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "reports@example.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenURI,
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "service-account.json")
+
+	if err := os.WriteFile(path, b, DefaultPerms); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	return path
+}
+
+func TestServiceAccountClient_Impersonation(t *testing.T) {
+	var claims map[string]any
+
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// JWT bearer assertion: header.claims.signature
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) == 3 {
+			if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+				_ = json.Unmarshal(b, &claims)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"sa-access","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	var gotAuth string
+
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer apiSrv.Close()
+
+	path := writeServiceAccountKey(t, tokenSrv.URL)
+
+	client, err := ServiceAccountClient(context.Background(), path, "user@example.com", "scope-a")
+	if err != nil {
+		t.Fatalf("ServiceAccountClient: %v", err)
+	}
+
+	resp, err := client.Get(apiSrv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if gotAuth != "Bearer sa-access" {
+		t.Errorf("Authorization: got %q, want Bearer sa-access", gotAuth)
+	}
+
+	if claims["sub"] != "user@example.com" {
+		t.Errorf("sub claim: got %v, want user@example.com", claims["sub"])
+	}
+
+	if claims["scope"] != "scope-a" {
+		t.Errorf("scope claim: got %v, want scope-a", claims["scope"])
+	}
+}
+
+func TestServiceAccountClient_MissingKey(t *testing.T) {
+	_, err := ServiceAccountClient(context.Background(), filepath.Join(t.TempDir(), "missing.json"), "")
+	if !errors.Is(err, ErrServiceAccountRead) {
+		t.Fatalf("error: got %v, want ErrServiceAccountRead", err)
+	}
+}
+
+func TestServiceAccountClient_InvalidKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.json")
+
+	if err := os.WriteFile(path, []byte(`{"type":"authorized_user"}`), DefaultPerms); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err := ServiceAccountClient(context.Background(), path, "")
+	if !errors.Is(err, ErrServiceAccountKey) {
+		t.Fatalf("error: got %v, want ErrServiceAccountKey", err)
+	}
+}
+
+func TestServiceAccountEmail(t *testing.T) {
+	email, err := ServiceAccountEmail(writeServiceAccountKey(t, "http://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("ServiceAccountEmail: %v", err)
+	}
+
+	if email != "reports@example.iam.gserviceaccount.com" {
+		t.Errorf("email: got %q, want reports@example.iam.gserviceaccount.com", email)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// maxStatusBodySize is a maximum size of revocation and token info responses.
+const maxStatusBodySize = 1 << 20
+
+var (
+	// RevokeURL is the Google OAuth2 token revocation endpoint.
+	RevokeURL = "https://oauth2.googleapis.com/revoke"
+
+	// TokenInfoURL is the Google OAuth2 token information endpoint.
+	TokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+var (
+	ErrOAuthRevoke    = errors.New("unable to revoke token")
+	ErrOAuthTokenInfo = errors.New("unable to retrieve token information")
+	ErrOAuthNoToken   = errors.New("token has neither a refresh nor an access token")
+)
+
+// TokenInfo describes a token as seen by the authorization server.
+type TokenInfo struct {
+	Expiry time.Time
+	Email  string
+	Scopes []string
+}
+
+// tokenInfoResponse is a tokeninfo endpoint JSON response; numbers are encoded as strings.
+type tokenInfoResponse struct {
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expires_in"`
+	Email     string `json:"email"`
+}
+
+// oauthErrorResponse is an OAuth2 error JSON response.
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// LoadToken loads the token from store and refreshes it if expired, saving the refreshed token. Unlike GetClient it
+// never runs an interactive flow, so an expired token without a refresh token is returned as is and callers must check
+// Valid.
+func LoadToken(ctx context.Context, config *oauth2.Config, store TokenStore) (*oauth2.Token, error) {
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.Valid() || tok.RefreshToken == "" {
+		return tok, nil
+	}
+
+	newTok, err := config.TokenSource(ctx, tok).Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenFetch, err)
+	}
+
+	if err := store.Save(newTok); err != nil {
+		return nil, err
+	}
+
+	return newTok, nil
+}
+
+// RevokeToken revokes the refresh token, or the access token if there is none. Revoking a refresh token also revokes
+// access tokens issued from it. A token the server reports as already invalid is considered revoked.
+func RevokeToken(ctx context.Context, tok *oauth2.Token) (err error) {
+	value := tok.RefreshToken
+	if value == "" {
+		value = tok.AccessToken
+	}
+
+	if value == "" {
+		return fmt.Errorf("%w: %w", ErrOAuthRevoke, ErrOAuthNoToken)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, RevokeURL,
+		strings.NewReader(url.Values{"token": {value}}.Encode()))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthRevoke, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthRevoke, err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("%w: %w", ErrOAuthRevoke, closeErr)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var oauthErr oauthErrorResponse
+
+	_ = json.NewDecoder(io.LimitReader(resp.Body, maxStatusBodySize)).Decode(&oauthErr)
+
+	// expired, revoked or unknown tokens are no longer usable anyway
+	if oauthErr.Error == "invalid_token" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s %s", ErrOAuthRevoke, resp.Status, oauthErr.Error)
+}
+
+// GetTokenInfo queries the authorization server for scopes granted to the access token and its expiry.
+func GetTokenInfo(ctx context.Context, tok *oauth2.Token) (info *TokenInfo, err error) {
+	u, err := url.Parse(TokenInfoURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenInfo, err)
+	}
+
+	u.RawQuery = url.Values{"access_token": {tok.AccessToken}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenInfo, err)
+	}
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenInfo, err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("%w: %w", ErrOAuthTokenInfo, closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrOAuthTokenInfo, resp.Status)
+	}
+
+	var r tokenInfoResponse
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxStatusBodySize)).Decode(&r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOAuthTokenInfo, err)
+	}
+
+	info = &TokenInfo{Email: r.Email, Scopes: strings.Fields(r.Scope)}
+
+	if secs, err := strconv.Atoi(r.ExpiresIn); err == nil {
+		info.Expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// setURL points an endpoint URL variable at url for the duration of the test.
+func setURL(t *testing.T, v *string, url string) {
+	t.Helper()
+
+	orig := *v
+	*v = url
+
+	t.Cleanup(func() { *v = orig })
+}
+
+func TestRevokeToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"revoked", http.StatusOK, `{}`, false},
+		{"already invalid", http.StatusBadRequest, `{"error":"invalid_token"}`, false},
+		{"server error", http.StatusServiceUnavailable, `{"error":"backend_error"}`, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotToken string
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotToken = r.FormValue("token")
+
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			setURL(t, &RevokeURL, srv.URL)
+
+			err := RevokeToken(context.Background(), &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("RevokeToken: got %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrOAuthRevoke) {
+				t.Errorf("error: got %v, want ErrOAuthRevoke", err)
+			}
+
+			// The refresh token must be revoked, which also invalidates issued access tokens
+			if gotToken != "refresh" {
+				t.Errorf("revoked token: got %q, want refresh", gotToken)
+			}
+		})
+	}
+}
+
+func TestRevokeToken_Empty(t *testing.T) {
+	if err := RevokeToken(context.Background(), &oauth2.Token{}); !errors.Is(err, ErrOAuthNoToken) {
+		t.Fatalf("error: got %v, want ErrOAuthNoToken", err)
+	}
+}
+
+func TestGetTokenInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "access" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"scope":"openid https://www.googleapis.com/auth/calendar.readonly",` +
+			`"expires_in":"1800","email":"user@example.com"}`))
+	}))
+	defer srv.Close()
+
+	setURL(t, &TokenInfoURL, srv.URL)
+
+	info, err := GetTokenInfo(context.Background(), &oauth2.Token{AccessToken: "access"})
+	if err != nil {
+		t.Fatalf("GetTokenInfo: %v", err)
+	}
+
+	if want := []string{"openid", "https://www.googleapis.com/auth/calendar.readonly"}; !slices.Equal(info.Scopes, want) {
+		t.Errorf("Scopes: got %v, want %v", info.Scopes, want)
+	}
+
+	if info.Email != "user@example.com" {
+		t.Errorf("Email: got %q, want user@example.com", info.Email)
+	}
+
+	if d := time.Until(info.Expiry); d < 29*time.Minute || d > 30*time.Minute {
+		t.Errorf("Expiry: got %v from now, want about 30m", d)
+	}
+
+	if _, err := GetTokenInfo(context.Background(), &oauth2.Token{AccessToken: "other"}); !errors.Is(err, ErrOAuthTokenInfo) {
+		t.Errorf("invalid token: got %v, want ErrOAuthTokenInfo", err)
+	}
+}
+
+func TestLoadToken_RefreshesExpired(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	config := &oauth2.Config{ClientID: "client-id", Endpoint: oauth2.Endpoint{TokenURL: tokenSrv.URL}}
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "token.json")}
+
+	if err := store.Save(&oauth2.Token{
+		AccessToken:  "stale",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	tok, err := LoadToken(context.Background(), config, store)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+
+	if tok.AccessToken != "fresh" {
+		t.Errorf("AccessToken: got %q, want fresh", tok.AccessToken)
+	}
+
+	if saved, _ := store.Load(); saved.AccessToken != "fresh" {
+		t.Errorf("saved AccessToken: got %q, want fresh", saved.AccessToken)
+	}
+}
+
+func TestLoadToken_ExpiredWithoutRefreshToken(t *testing.T) {
+	config := &oauth2.Config{ClientID: "client-id", Endpoint: oauth2.Endpoint{TokenURL: "http://127.0.0.1:1"}}
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "token.json")}
+
+	if err := store.Save(&oauth2.Token{AccessToken: "stale", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	tok, err := LoadToken(context.Background(), config, store)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+
+	// Without a refresh token the expired token is returned unchanged, never shown as usable
+	if tok.Valid() {
+		t.Errorf("Valid: got true for a token expired at %v", tok.Expiry)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/renameio/v2/maybe"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// encryptedVersion is the encrypted token file format version.
+	encryptedVersion = 1
+
+	// scrypt key derivation parameters for a 256-bit AES key, as recommended for interactive logins.
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+)
+
+var (
+	ErrTokenPassphrase = errors.New("token passphrase is required")
+	ErrTokenDecrypt    = errors.New("unable to decrypt token, wrong passphrase or corrupted file")
+	ErrTokenFormat     = errors.New("unsupported encrypted token format")
+	ErrTokenKeyring    = errors.New("unable to access system keyring")
+	ErrTokenNotFound   = errors.New("no stored token")
+	ErrTokenDelete     = errors.New("unable to delete token")
+)
+
+// TokenStore loads, saves and deletes an OAuth2 token.
+type TokenStore interface {
+	Load() (*oauth2.Token, error)
+	Save(token *oauth2.Token) error
+	Delete() error
+}
+
+// FileStore stores a token as plaintext JSON in a file readable only by the owner.
+type FileStore struct {
+	Path string
+}
+
+// Load reads the token from the file.
+func (s *FileStore) Load() (*oauth2.Token, error) {
+	return tokenFromFile(s.Path)
+}
+
+// Save atomically writes the token to the file.
+func (s *FileStore) Save(token *oauth2.Token) error {
+	return saveToken(s.Path, token)
+}
+
+// Delete removes the token file.
+func (s *FileStore) Delete() error {
+	return deleteTokenFile(s.Path)
+}
+
+// EncryptedFileStore stores a token in a file encrypted with AES-256-GCM, using a key derived from a passphrase
+// through scrypt. A fresh salt and nonce are generated on every save.
+type EncryptedFileStore struct {
+	Path       string
+	Passphrase []byte
+}
+
+// encryptedToken is the on-disk encrypted token envelope.
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Load reads and decrypts the token from the file.
+func (s *EncryptedFileStore) Load() (*oauth2.Token, error) {
+	if len(s.Passphrase) == 0 {
+		return nil, ErrTokenPassphrase
+	}
+
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var env encryptedToken
+
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenFormat, err)
+	}
+
+	if env.Version != encryptedVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrTokenFormat, env.Version)
+	}
+
+	gcm, err := newGCM(s.Passphrase, env.Salt, env.N, env.R, env.P)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrTokenFormat)
+	}
+
+	plain, err := gcm.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrTokenDecrypt
+	}
+
+	tok := &oauth2.Token{}
+
+	err = json.Unmarshal(plain, tok)
+
+	return tok, err
+}
+
+// Save encrypts and atomically writes the token to the file.
+func (s *EncryptedFileStore) Save(token *oauth2.Token) error {
+	if len(s.Passphrase) == 0 {
+		return ErrTokenPassphrase
+	}
+
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenEncode, err)
+	}
+
+	env := encryptedToken{
+		Version: encryptedVersion,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, scryptSalt),
+	}
+
+	if _, err := rand.Read(env.Salt); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
+	}
+
+	gcm, err := newGCM(s.Passphrase, env.Salt, env.N, env.R, env.P)
+	if err != nil {
+		return err
+	}
+
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
+	}
+
+	env.Ciphertext = gcm.Seal(nil, env.Nonce, plain, nil)
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(env); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenEncode, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), DefaultDirPerms); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
+	}
+
+	if err := maybe.WriteFile(s.Path, buf.Bytes(), DefaultPerms); err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenSave, err)
+	}
+
+	return nil
+}
+
+// Delete removes the encrypted token file.
+func (s *EncryptedFileStore) Delete() error {
+	return deleteTokenFile(s.Path)
+}
+
+// deleteTokenFile removes a token file, reporting a missing file as ErrTokenNotFound.
+func deleteTokenFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrTokenNotFound, err)
+		}
+
+		return fmt.Errorf("%w: %w", ErrTokenDelete, err)
+	}
+
+	return nil
+}
+
+// newGCM derives an AES-256 key from passphrase and salt with scrypt and returns an AES-GCM cipher.
+func newGCM(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenFormat, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// KeyringStore stores a token in the system keyring: Secret Service over D-Bus on Linux, Keychain on macOS and
+// Credential Manager on Windows.
+type KeyringStore struct {
+	Service string
+	User    string
+}
+
+// Load reads the token from the keyring.
+func (s *KeyringStore) Load() (*oauth2.Token, error) {
+	secret, err := keyring.Get(s.Service, s.User)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenKeyring, err)
+	}
+
+	tok := &oauth2.Token{}
+
+	err = json.Unmarshal([]byte(secret), tok)
+
+	return tok, err
+}
+
+// Save writes the token to the keyring, replacing any existing one.
+func (s *KeyringStore) Save(token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOAuthTokenEncode, err)
+	}
+
+	if err := keyring.Set(s.Service, s.User, string(b)); err != nil {
+		return fmt.Errorf("%w: %w: %w", ErrOAuthTokenSave, ErrTokenKeyring, err)
+	}
+
+	return nil
+}
+
+// Delete removes the token from the keyring.
+func (s *KeyringStore) Delete() error {
+	if err := keyring.Delete(s.Service, s.User); err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("%w: %w", ErrTokenNotFound, err)
+		}
+
+		return fmt.Errorf("%w: %w: %w", ErrTokenDelete, ErrTokenKeyring, err)
+	}
+
+	return nil
+}
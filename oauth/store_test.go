@@ -0,0 +1,148 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+func testToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "store-access",
+		TokenType:    "Bearer",
+		RefreshToken: "store-refresh",
+		Expiry:       time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// checkDelete verifies that a stored token is deleted and that a second delete reports a missing token.
+func checkDelete(t *testing.T, store TokenStore) {
+	t.Helper()
+
+	if err := store.Delete(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := store.Load(); err == nil {
+		t.Error("Load after Delete: expected error")
+	}
+
+	if err := store.Delete(); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("second Delete: got %v, want ErrTokenNotFound", err)
+	}
+}
+
+// checkRoundTrip saves a token to store and verifies it loads back unchanged.
+func checkRoundTrip(t *testing.T, store TokenStore) {
+	t.Helper()
+
+	want := testToken()
+
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken || !got.Expiry.Equal(want.Expiry) {
+		t.Errorf("token: got %+v, want %+v", got, want)
+	}
+}
+
+func TestFileStore_RoundTrip(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "token.json")}
+
+	checkRoundTrip(t, store)
+	checkDelete(t, store)
+}
+
+func TestEncryptedFileStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json.enc")
+
+	checkRoundTrip(t, &EncryptedFileStore{Path: path, Passphrase: []byte("correct horse")})
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// Neither token may be stored in plaintext
+	if bytes.Contains(b, []byte("store-refresh")) || bytes.Contains(b, []byte("store-access")) {
+		t.Error("encrypted token file contains plaintext token")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != DefaultPerms {
+		t.Errorf("file permissions: got %o, want %o", perm, DefaultPerms)
+	}
+
+	checkDelete(t, &EncryptedFileStore{Path: path, Passphrase: []byte("correct horse")})
+}
+
+func TestEncryptedFileStore_WrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json.enc")
+
+	if err := (&EncryptedFileStore{Path: path, Passphrase: []byte("right")}).Save(testToken()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	_, err := (&EncryptedFileStore{Path: path, Passphrase: []byte("wrong")}).Load()
+	if !errors.Is(err, ErrTokenDecrypt) {
+		t.Fatalf("error: got %v, want ErrTokenDecrypt", err)
+	}
+}
+
+func TestEncryptedFileStore_PlaintextFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	if err := saveToken(path, testToken()); err != nil {
+		t.Fatalf("saveToken: %v", err)
+	}
+
+	_, err := (&EncryptedFileStore{Path: path, Passphrase: []byte("secret")}).Load()
+	if !errors.Is(err, ErrTokenFormat) {
+		t.Fatalf("error: got %v, want ErrTokenFormat", err)
+	}
+}
+
+func TestEncryptedFileStore_NoPassphrase(t *testing.T) {
+	store := &EncryptedFileStore{Path: filepath.Join(t.TempDir(), "token.json.enc")}
+
+	if err := store.Save(testToken()); !errors.Is(err, ErrTokenPassphrase) {
+		t.Errorf("Save error: got %v, want ErrTokenPassphrase", err)
+	}
+
+	if _, err := store.Load(); !errors.Is(err, ErrTokenPassphrase) {
+		t.Errorf("Load error: got %v, want ErrTokenPassphrase", err)
+	}
+}
+
+func TestKeyringStore_RoundTrip(t *testing.T) {
+	keyring.MockInit()
+
+	store := &KeyringStore{Service: "IM-billing-v2-test", User: "default"}
+
+	if _, err := store.Load(); !errors.Is(err, ErrTokenKeyring) {
+		t.Fatalf("Load before Save: got %v, want ErrTokenKeyring", err)
+	}
+
+	checkRoundTrip(t, store)
+	checkDelete(t, store)
+}
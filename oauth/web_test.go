@@ -0,0 +1,242 @@
+// Copyright (C) 2023  Dinko Korunic
+//
+// SPDX-License-Identifier: MIT
+
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newWebConfig returns a config whose token endpoint issues a token only for wantCode and a PKCE verifier matching
+// the S256 challenge stored in *challenge.
+func newWebConfig(t *testing.T, wantCode string, challenge *string) *oauth2.Config {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+
+		if r.FormValue("code") != wantCode || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"access_token":"web-access","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return &oauth2.Config{
+		ClientID: "client-id",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://accounts.example.com/auth",
+			TokenURL:  srv.URL,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// captureChallenge returns a browser stub recording the PKCE challenge of the auth URL, then calling next.
+func captureChallenge(t *testing.T, challenge *string, next func(*url.URL) error) func(string) error {
+	t.Helper()
+
+	return func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+
+		if method := u.Query().Get("code_challenge_method"); method != "S256" {
+			t.Errorf("code_challenge_method: got %q, want S256", method)
+		}
+
+		*challenge = u.Query().Get("code_challenge")
+
+		return next(u)
+	}
+}
+
+// callback requests the redirect uri of the auth URL u with state and extra query parameters.
+func callback(u *url.URL, extra string) {
+	q := u.Query()
+
+	resp, err := http.Get(q.Get("redirect_uri") + "/?state=" + url.QueryEscape(q.Get("state")) + "&" + extra)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+// stubBrowser replaces openBrowser for the duration of the test.
+func stubBrowser(t *testing.T, fn func(string) error) {
+	t.Helper()
+
+	orig := openBrowser
+	openBrowser = fn
+
+	t.Cleanup(func() { openBrowser = orig })
+}
+
+func TestParseAuthCode(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+		wantErr           error
+	}{
+		{"bare code", "4/0Abc-def", "4/0Abc-def", nil},
+		{"redirect URL", "http://127.0.0.1:8080/?state=s1&code=4/xyz&scope=cal", "4/xyz", nil},
+		{"state mismatch", "http://127.0.0.1:8080/?state=other&code=4/xyz", "", ErrOAuthState},
+		{"missing code", "http://127.0.0.1:8080/?state=s1", "", ErrOAuthNoCode},
+		{"access denied", "http://127.0.0.1:8080/?state=s1&error=access_denied", "", ErrOAuthAccessDenied},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAuthCode(tc.input, "s1")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error: got %v, want %v", err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("code: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetTokenFromWeb_BrowserFailureKeepsWaiting(t *testing.T) {
+	var challenge string
+
+	config := newWebConfig(t, "callback-code", &challenge)
+
+	// Simulate a remote user opening the URL elsewhere: the callback arrives although the browser failed
+	stubBrowser(t, captureChallenge(t, &challenge, func(u *url.URL) error {
+		go callback(u, "code=callback-code")
+
+		return errors.New("no display")
+	}))
+
+	tok, err := getTokenFromWeb(context.Background(), config, nil)
+	if err != nil {
+		t.Fatalf("getTokenFromWeb: %v", err)
+	}
+
+	if tok.AccessToken != "web-access" {
+		t.Errorf("AccessToken: got %q, want web-access", tok.AccessToken)
+	}
+}
+
+func TestGetTokenFromWeb_PastedCode(t *testing.T) {
+	var challenge string
+
+	config := newWebConfig(t, "pasted-code", &challenge)
+
+	stubBrowser(t, captureChallenge(t, &challenge, func(*url.URL) error { return errors.New("no display") }))
+
+	// Invalid lines are skipped until a usable code is pasted
+	input := strings.NewReader("\nhttp://127.0.0.1:1/?state=wrong&code=bad\npasted-code\n")
+
+	tok, err := getTokenFromWeb(context.Background(), config, input)
+	if err != nil {
+		t.Fatalf("getTokenFromWeb: %v", err)
+	}
+
+	if tok.AccessToken != "web-access" {
+		t.Errorf("AccessToken: got %q, want web-access", tok.AccessToken)
+	}
+}
+
+func TestGetClient_PastedCode(t *testing.T) {
+	var challenge string
+
+	config := newWebConfig(t, "pasted-code", &challenge)
+
+	stubBrowser(t, captureChallenge(t, &challenge, func(*url.URL) error { return errors.New("no display") }))
+
+	// Without a stored token, the interactive login offers the same paste fallback as auth login --paste
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "token.json")}
+
+	_, err := GetClient(context.Background(), config, store, LoginOptions{
+		Paste: true,
+		Input: strings.NewReader("pasted-code\n"),
+	})
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+
+	if tok, err := store.Load(); err != nil || tok.AccessToken != "web-access" {
+		t.Errorf("saved token: got %v (%v), want web-access", tok, err)
+	}
+}
+
+func TestGetTokenFromWeb_AccessDenied(t *testing.T) {
+	var challenge string
+
+	config := newWebConfig(t, "unused", &challenge)
+
+	stubBrowser(t, captureChallenge(t, &challenge, func(u *url.URL) error {
+		go callback(u, "error=access_denied&error_description=User+declined")
+
+		return nil
+	}))
+
+	start := time.Now()
+
+	_, err := getTokenFromWeb(context.Background(), config, nil)
+	if !errors.Is(err, ErrOAuthAccessDenied) {
+		t.Fatalf("error: got %v, want ErrOAuthAccessDenied", err)
+	}
+
+	var cbErr *CallbackError
+	if !errors.As(err, &cbErr) || cbErr.Description != "User declined" {
+		t.Errorf("CallbackError: got %+v", cbErr)
+	}
+
+	// The error must end the flow immediately instead of waiting for AuthTimeout
+	if elapsed := time.Since(start); elapsed > AuthTimeout/2 {
+		t.Errorf("flow ended after %v, want immediately", elapsed)
+	}
+}
+
+func TestGetTokenFromWeb_PastedError(t *testing.T) {
+	var challenge string
+
+	config := newWebConfig(t, "unused", &challenge)
+
+	stateChan := make(chan string, 1)
+
+	stubBrowser(t, captureChallenge(t, &challenge, func(u *url.URL) error {
+		stateChan <- u.Query().Get("state")
+
+		return errors.New("no display")
+	}))
+
+	r, w := io.Pipe()
+	defer func() { _ = w.Close() }()
+
+	// Paste only once the auth URL, and with it the state, is known
+	go func() {
+		_, _ = io.WriteString(w, "http://127.0.0.1:1/?state="+url.QueryEscape(<-stateChan)+"&error=server_error\n")
+	}()
+
+	_, err := getTokenFromWeb(context.Background(), config, r)
+
+	var cbErr *CallbackError
+	if !errors.As(err, &cbErr) || cbErr.Code != "server_error" {
+		t.Fatalf("error: got %v, want CallbackError server_error", err)
+	}
+}
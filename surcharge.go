@@ -0,0 +1,63 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+// multipliers returns surcharge multipliers configured by flags.
+func multipliers() billing.Multipliers {
+	return billing.Multipliers{
+		Saturday:   *surchargeSaturday,
+		Sunday:     *surchargeSunday,
+		Holiday:    *surchargeHoliday,
+		AfterHours: *surchargeAfterHours,
+	}
+}
+
+// printSurchargeStats displays weekend and after-hours work and, with an hourly rate, the billed amount with a
+// separate line per surcharge category.
+func printSurchargeStats(report *billing.Report) {
+	// Public holidays are listed separately, only weekends and after-hours work are flagged here
+	if flaggedKeys := report.FlaggedDates(); len(flaggedKeys) > 0 {
+		fmt.Printf("\nYou have calendar events on weekends or outside working hours:\n")
+
+		for _, k := range flaggedKeys {
+			v := report.Days[k]
+
+			if c := report.Category(k); c != billing.CategoryRegular {
+				fmt.Printf("%10s\t%2d\t%v\n", k, v.Hours, c)
+			} else {
+				fmt.Printf("%10s\t%2d\t%s\n", k, v.AfterHours, billing.AfterHoursName)
+			}
+		}
+	}
+
+	rate := *hourlyRate
+	if rate <= 0 {
+		return
+	}
+
+	totalHours := report.TotalHours()
+	m := multipliers()
+
+	fmt.Printf("\nBilled amount at %.2f per hour:\n", rate)
+	fmt.Printf("%-32s%6dh\t%12.2f\n", "Base", totalHours, float64(totalHours)*rate)
+
+	// Surcharge is the amount on top of the base amount, already billed at the regular rate
+	for _, l := range report.Surcharges(m) {
+		if l.Hours == 0 || l.Multiplier == 1 {
+			continue
+		}
+
+		fmt.Printf("%-32s%6dh\t%12.2f\n", fmt.Sprintf("%s surcharge (x%.2f)", l.Name, l.Multiplier), l.Hours,
+			l.Amount(rate))
+	}
+
+	fmt.Printf("%-32s%7s\t%12.2f\n", "Total billed amount", "", report.BilledAmount(rate, m))
+}
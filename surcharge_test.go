@@ -0,0 +1,89 @@
+// Copyright (C) 2018  Dinko Korunic, InfoMAR
+//
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dkorunic/IM-billing-v2/billing"
+)
+
+// setSurchargeGlobals sets hourly rate and surcharge multiplier globals and restores them on cleanup.
+func setSurchargeGlobals(t *testing.T, rate, saturday, sunday, holiday, after float64) {
+	t.Helper()
+
+	origRate := hourlyRate
+	origSaturday := surchargeSaturday
+	origSunday := surchargeSunday
+	origHoliday := surchargeHoliday
+	origAfter := surchargeAfterHours
+
+	t.Cleanup(func() {
+		hourlyRate = origRate
+		surchargeSaturday = origSaturday
+		surchargeSunday = origSunday
+		surchargeHoliday = origHoliday
+		surchargeAfterHours = origAfter
+	})
+
+	hourlyRate = &rate
+	surchargeSaturday = &saturday
+	surchargeSunday = &sunday
+	surchargeHoliday = &holiday
+	surchargeAfterHours = &after
+}
+
+func TestPrintSurchargeStats_BilledAmount(t *testing.T) {
+	setSurchargeGlobals(t, 100, 1.5, 2, 2, 1.25)
+
+	report := billing.NewReport(billing.Query{})
+	report.Days["2024-01-13"] = billing.NewDay("Saturday work", 4, 0)
+	report.Days["2024-01-15"] = billing.NewDay("Late work", 8, 2)
+	report.Days["2024-01-16"] = billing.NewDay("Normal work", 8, 0)
+	report.Days["2024-01-06"] = billing.NewDay("Holiday work", 2, 0)
+	report.Holidays["2024-01-06"] = billing.Holiday{Description: "Epiphany"}
+
+	output := captureStdout(t, func() {
+		printSurchargeStats(report)
+	})
+
+	for _, want := range []string{
+		"2024-01-13\t 4\tSaturday",
+		"2024-01-15\t 2\tAfter hours",
+		"Saturday surcharge (x1.50)",
+		"Holiday surcharge (x2.00)",
+		"After hours surcharge (x1.25)",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output missing %q:\n%s", want, output)
+		}
+	}
+
+	// Regular work without after-hours and Sunday without work must not be listed
+	if strings.Contains(output, "2024-01-16") || strings.Contains(output, "Sunday surcharge") {
+		t.Errorf("unexpected regular day or empty surcharge category in output:\n%s", output)
+	}
+
+	// 22h*100 base + 4h*100*0.5 + 2h*100*1 + 2h*100*0.25 = 2200 + 200 + 200 + 50
+	if !strings.Contains(output, "2650.00") {
+		t.Errorf("total billed amount 2650.00 not found in output:\n%s", output)
+	}
+}
+
+func TestPrintSurchargeStats_NoRate(t *testing.T) {
+	setSurchargeGlobals(t, 0, 1.5, 2, 2, 1)
+
+	report := billing.NewReport(billing.Query{})
+	report.Days["2024-01-13"] = billing.NewDay("Saturday work", 4, 0)
+
+	output := captureStdout(t, func() {
+		printSurchargeStats(report)
+	})
+
+	if strings.Contains(output, "Billed amount") {
+		t.Errorf("billed amount must not be shown without a rate:\n%s", output)
+	}
+}